@@ -0,0 +1,78 @@
+package cli
+
+import (
+	"fmt"
+	"time"
+)
+
+const (
+	budgetPages    = "max-pages"
+	budgetDuration = "max-duration"
+	budgetBytes    = "max-bytes"
+)
+
+// budgetHit returns the flag name of the first crawl budget that is used up,
+// or an empty string while the crawl may continue.
+func (c *crawler) budgetHit() string {
+	if c.app.maxPages > 0 && c.scheduled >= c.app.maxPages {
+		return budgetPages
+	}
+	return c.fetchBudgetHit()
+}
+
+// fetchBudgetHit returns the flag name of the first used up budget that also
+// ends fetching, as opposed to -max-pages, which only ends enqueueing.
+func (c *crawler) fetchBudgetHit() string {
+	switch {
+	case c.app.maxDuration > 0 && c.now().Sub(c.started) >= c.app.maxDuration:
+		return budgetDuration
+	case c.app.maxBytes > 0 && c.downloaded >= c.app.maxBytes:
+		return budgetBytes
+	}
+	return ""
+}
+
+// exhausted reports whether a budget has ended the crawl. Once it has, no
+// further pages are enqueued. The pages scheduled within -max-pages are still
+// fetched, but once -max-duration or -max-bytes is used up queued pages are
+// skipped too and only fetches already in flight are finished.
+func (c *crawler) exhausted() bool {
+	if c.stoppedBy == "" || c.stoppedBy == budgetPages {
+		if hit := c.fetchBudgetHit(); hit != "" {
+			c.stoppedBy = hit
+			if c.stop != nil {
+				close(c.stop)
+			}
+		} else {
+			c.stoppedBy = c.budgetHit()
+		}
+	}
+	return c.stoppedBy != ""
+}
+
+func (c *crawler) budgetReport() string {
+	var limit string
+	switch c.stoppedBy {
+	case budgetPages:
+		limit = fmt.Sprintf("%d pages", c.app.maxPages)
+	case budgetDuration:
+		limit = c.app.maxDuration.String()
+	case budgetBytes:
+		limit = fmt.Sprintf("%d bytes", c.app.maxBytes)
+	default:
+		return ""
+	}
+
+	report := fmt.Sprintf("Crawl ended by the -%s budget of %s after %d pages and %d bytes", c.stoppedBy, limit, c.processed, c.downloaded)
+	if c.skipped > 0 {
+		report += fmt.Sprintf(", skipping %d queued pages", c.skipped)
+	}
+	return report
+}
+
+func (c *crawler) now() time.Time {
+	if c.clock != nil {
+		return c.clock()
+	}
+	return time.Now()
+}
@@ -1,17 +1,36 @@
 package cli
 
 import (
-	"bufio"
 	"context"
-	"encoding/xml"
+	"errors"
 	"fmt"
+	"net/url"
 	"os"
+	"path/filepath"
+	"sort"
+	"strings"
 	"time"
 
+	"github.com/Mihai22125/oronoxyl/internal/state"
+	"github.com/Mihai22125/oronoxyl/pkg/metrics"
 	"github.com/Mihai22125/oronoxyl/pkg/sitemap"
 	"github.com/Mihai22125/oronoxyl/pkg/workerpool"
 )
 
+const (
+	reasonIncluded          = "included"
+	reasonNoList            = "matches a -no-list pattern"
+	reasonExcluded          = "outside the -include/-exclude scope"
+	reasonRule              = "excluded by the rule on line %d"
+	reasonBroken            = "HTTP status %d"
+	reasonNoPublicationDate = "no publication date"
+	reasonTooOld            = "published more than 48 hours ago"
+	reasonNewsLimit         = "news sitemap limit reached"
+)
+
+// errBrokenLinks fails crawls run with -fail-on-broken that found broken links.
+var errBrokenLinks = errors.New("broken links found")
+
 func CLI(args []string) int {
 	var app appEnv
 	err := app.fromArgs(args)
@@ -19,7 +38,10 @@ func CLI(args []string) int {
 		return 2
 	}
 
-	if err = app.run(); err != nil {
+	if err = app.run(); errors.Is(err, errBrokenLinks) {
+		fmt.Fprintf(os.Stderr, "\n%v\n", err)
+		return 3
+	} else if err != nil {
 		fmt.Fprintf(os.Stderr, "Runtime error: %v\n", err)
 		return 1
 	}
@@ -27,78 +49,661 @@ func CLI(args []string) int {
 	return 0
 }
 
+type crawler struct {
+	app     *appEnv
+	parser  *sitemap.Parser
+	wp      workerpool.WorkerPool
+	journal *state.Journal
+	stats   *crawlMetrics
+
+	started   time.Time
+	seen      map[string]bool
+	previous  state.Index
+	current   state.Index
+	pages     []sitemap.Page
+	processed int
+	emitted   int
+	unchanged int
+	newsFull  bool
+
+	scheduled  int
+	downloaded int64
+	stoppedBy  string
+	clock      func() time.Time
+	stop       chan struct{}
+	skipped    int
+
+	traps *sitemap.TrapDetector
+
+	failures  map[string]string
+	redirects []sitemap.RedirectChain
+}
+
 func (app *appEnv) run() error {
-	wp := workerpool.New(app.parallelWorkers)
+	c := &crawler{
+		app:      app,
+		parser:   app.parser(),
+		wp:       workerpool.New(app.parallelWorkers),
+		stats:    newCrawlMetrics(),
+		started:  time.Now().UTC().Truncate(time.Second),
+		previous: make(state.Index),
+		current:  make(state.Index),
+		traps:    sitemap.NewTrapDetector(app.trapLimits),
+		failures: make(map[string]string),
+		stop:     make(chan struct{}),
+	}
 
 	ctx, cancel := context.WithCancel(context.TODO())
 	defer cancel()
 
-	go wp.Run(ctx)
-
-	wp.GenerateFromJob(generateJob(PageJob{Url: app.url, Depth: 1}))
-
-	seen := make(map[string]bool)
-	var processed int
+	if app.metricsAddr != "" {
+		srv, err := metrics.Serve(app.metricsAddr, c.stats.registry)
+		if err != nil {
+			return err
+		}
+		defer srv.Shutdown(context.Background())
+	}
 
-	file, err := os.Create(app.outputFile)
+	journal, checkpoint, err := app.openJournal()
 	if err != nil {
 		return err
 	}
+	defer journal.Close()
+	c.journal = journal
+	c.seen = checkpoint.Seen
+	c.redirects = checkpoint.Redirects
+	for url, reason := range checkpoint.Failures {
+		c.failures[url] = reason
+	}
+
+	if app.stateDir != "" {
+		if c.previous, err = state.LoadIndex(app.stateDir); err != nil {
+			return err
+		}
+	}
 
-	writer := bufio.NewWriter(file)
-	writer.Write([]byte("<urlset>\n"))
+	go c.wp.Run(ctx)
 
 	start := time.Now()
 	defer func() {
-		writer.Write([]byte("\n</urlset>"))
-		writer.Flush()
-		file.Close()
 		if app.verbose {
 			fmt.Fprintf(os.Stderr, "\nTime finished sitemap %s\n", time.Since(start))
+			if app.incremental {
+				fmt.Fprintf(os.Stderr, "Pages unchanged since last crawl: %d\n", c.unchanged)
+			}
 		}
 	}()
 
+	if len(checkpoint.Pages) == 0 && len(checkpoint.Frontier) == 0 {
+		c.seen[app.url] = true
+		if err := c.schedule(app.url, 1); err != nil {
+			return err
+		}
+	}
+
+	for _, page := range checkpoint.Pages {
+		c.seen[page.Location] = true
+		c.emit(page)
+		if err := c.expand(page); err != nil {
+			return err
+		}
+	}
+
+	c.scheduled += len(checkpoint.Pages) + len(checkpoint.Frontier)
+	for _, entry := range checkpoint.Frontier {
+		c.wp.GenerateFromJob(generateJob(c.parser, c.newJob(entry.URL, entry.Depth)))
+	}
+
+	return c.loop()
+}
+
+func (c *crawler) loop() error {
 	for {
-		if app.verbose {
-			fmt.Fprintf(os.Stderr, "\rURLs Found: %5d\t\t Pages Processed: %5d\t\t Queue: %5d", processed+wp.GetQueueSize(), processed, wp.GetQueueSize())
+		if c.app.verbose {
+			fmt.Fprintf(os.Stderr, "\rURLs Found: %5d\t\t Pages Processed: %5d\t\t Queue: %5d", c.processed+c.wp.GetQueueSize(), c.processed, c.wp.GetQueueSize())
 		}
-		if wp.Working == 0 {
-			wp.CloseJobsChannel()
+		c.stats.queueDepth.Set(float64(c.wp.GetQueueSize()))
+		if c.wp.Working == 0 {
+			c.wp.CloseJobsChannel()
 		}
 		select {
-		case r, ok := <-wp.Results():
+		case r, ok := <-c.wp.Results():
 			if !ok {
 				continue
 			}
-			wp.Working--
+			c.wp.Working--
 
-			if r.Err != nil {
-				continue
+			if err := c.handle(r); err != nil {
+				return err
 			}
 
-			page := r.Value.(sitemap.Page)
-			data, err := xml.MarshalIndent(page, " ", "  ")
-			if err != nil {
-				if app.verbose {
-					fmt.Fprintf(os.Stderr, "An error occured while Marshling to XML: %v\n", err)
-				}
+		case <-c.wp.Done:
+			return c.finish()
+		default:
+		}
+	}
+}
+
+func (c *crawler) handle(r workerpool.Result) error {
+	if errors.Is(r.Err, errBudgetExhausted) {
+		c.skipped++
+		return nil
+	}
+
+	if r.Err != nil {
+		c.stats.observeError(r.Err)
+
+		var chain *sitemap.RedirectChain
+		var redirectErr *sitemap.RedirectError
+		if errors.As(r.Err, &redirectErr) {
+			chain = &redirectErr.Chain
+			c.redirects = append(c.redirects, redirectErr.Chain)
+		}
+
+		var pageErr *pageError
+		if errors.As(r.Err, &pageErr) {
+			var reason string
+			if !errors.Is(pageErr.err, sitemap.ErrNotSameHost) {
+				reason = pageErr.err.Error()
+				c.failures[pageErr.job.Url] = reason
 			}
-			writer.Write(data)
-			processed++
+			return c.journal.Fail(pageErr.job.Url, reason, chain)
+		}
+		return nil
+	}
+
+	page := r.Value.(sitemap.Page)
+	c.stats.observePage(page)
+	c.downloaded += page.Size
+	c.exhausted()
 
-			if page.Depth < app.maxDepth {
+	if len(page.Redirects) > 0 {
+		chain := sitemap.RedirectChain{Hops: page.Redirects, Final: page.Location}
+		c.redirects = append(c.redirects, chain)
 
-				for _, link := range page.Links {
-					if !seen[link] {
-						seen[link] = true
-						wp.GenerateFromJob(generateJob(PageJob{Url: link, Depth: page.Depth + 1}))
-					}
-				}
+		// Only the final URL is listed, once, however many URLs redirect to it.
+		if c.seen[page.Location] {
+			return c.journal.Fail(page.Redirects[0].URL, "", &chain)
+		}
+		c.seen[page.Location] = true
+	}
+
+	if page.NotModified {
+		page = c.reuse(page)
+	}
+
+	if c.app.contentLastmod {
+		page = c.hashLastmod(page)
+	}
+
+	if err := c.journal.Page(page); err != nil {
+		return err
+	}
+
+	c.emit(page)
+
+	return c.expand(page)
+}
+
+func (c *crawler) finish() error {
+	if report := c.budgetReport(); report != "" {
+		fmt.Fprintf(os.Stderr, "\n%s\n", report)
+	}
+
+	for _, trap := range c.traps.Traps() {
+		fmt.Fprintf(os.Stderr, "\ncrawler trap %s", trap)
+	}
+
+	for _, issue := range sitemap.AuditRedirects(c.redirects, c.app.redirectMaxHops) {
+		fmt.Fprintf(os.Stderr, "\nredirect %s", issue)
+	}
+	if usage := redirectUsage(c.redirects); usage != "" {
+		fmt.Fprintf(os.Stderr, "\nredirects by status: %s\n", usage)
+	}
+
+	if c.app.priorityStrategy != nil {
+		c.app.priorityStrategy.Assign(c.pages)
+	}
+	c.app.rules.Apply(c.pages)
+
+	if c.app.hreflang {
+		for _, issue := range sitemap.ApplyHreflang(c.pages) {
+			fmt.Fprintf(os.Stderr, "\nhreflang %s", issue)
+		}
+	}
+
+	if err := c.writeSitemap(); err != nil {
+		return err
+	}
+
+	if c.app.bundleStylesheet {
+		if err := c.writeStylesheet(); err != nil {
+			return err
+		}
+	}
+
+	if c.app.csvReport != "" {
+		if err := c.writeCSVReport(); err != nil {
+			return err
+		}
+	}
+
+	if c.app.graphFile != "" {
+		if err := c.writeGraph(); err != nil {
+			return err
+		}
+	}
+
+	var broken []sitemap.BrokenLink
+	if c.app.reportsBrokenLinks() {
+		broken = sitemap.FindBrokenLinks(c.pages, c.failures, c.redirects)
+	}
+	if c.app.brokenLinksFile != "" {
+		if err := c.writeBrokenLinks(broken); err != nil {
+			return err
+		}
+	}
+
+	if c.app.stateDir != "" {
+		c.current.Carry(c.previous)
+		if err := c.current.Save(c.app.stateDir); err != nil {
+			return err
+		}
+	}
+
+	if err := c.journal.Done(); err != nil {
+		return err
+	}
+
+	if c.app.failOnBroken && len(broken) > 0 {
+		return fmt.Errorf("%w: %d", errBrokenLinks, len(broken))
+	}
+
+	return nil
+}
+
+// newJob attaches the validators saved by the previous crawl only with
+// -incremental, as other users of the index must not make requests conditional.
+// They are looked up for every URL requested, as the index keeps redirected
+// pages under their final URL.
+func (c *crawler) newJob(url string, depth int) PageJob {
+	job := PageJob{Url: url, Depth: depth, Stop: c.stop}
+	if c.app.incremental {
+		job.Validators = c.previousValidators
+	}
+	return job
+}
+
+func (c *crawler) previousValidators(url string) sitemap.Validators {
+	return c.previous[url].Validators
+}
+
+func (c *crawler) schedule(url string, depth int) error {
+	if err := c.journal.Enqueue(state.Entry{URL: url, Depth: depth}); err != nil {
+		return err
+	}
+
+	c.wp.GenerateFromJob(generateJob(c.parser, c.newJob(url, depth)))
+	c.scheduled++
+	return nil
+}
+
+func (c *crawler) expand(page sitemap.Page) error {
+	if page.Depth >= c.app.maxDepth {
+		return nil
+	}
+
+	for _, link := range page.Links {
+		if !c.seen[link] && c.parser.Decide(link) != sitemap.ScopeSkip {
+			if c.exhausted() {
+				return nil
+			}
+			c.seen[link] = true
+			if c.trapped(link) {
+				continue
+			}
+			if err := c.schedule(link, page.Depth+1); err != nil {
+				return err
 			}
+		}
+	}
 
-		case <-wp.Done:
-			return nil
-		default:
+	return nil
+}
+
+// trapped reports whether link looks like a crawler trap and must not be
+// crawled.
+func (c *crawler) trapped(link string) bool {
+	if c.traps == nil {
+		return false
+	}
+
+	linkUrl, err := url.Parse(link)
+	if err != nil {
+		return false
+	}
+	return c.traps.Check(linkUrl)
+}
+
+// reuse fills a page the server reported as unchanged with what the previous
+// crawl extracted from it.
+func (c *crawler) reuse(page sitemap.Page) sitemap.Page {
+	previous := c.previous[page.Location]
+	page.Links = previous.Links
+	if previous.Lastmod != nil && !previous.Lastmod.IsZero() {
+		page.LastModified = previous.Lastmod
+	}
+	page.ContentHash = previous.Hash
+	page.Images = previous.Images
+	page.Videos = previous.Videos
+	page.Title = previous.Title
+	page.Published = previous.Published
+	page.Alternates = previous.Alternates
+	page.ContentType = previous.ContentType
+	page.Description = previous.Description
+	page.Canonical = previous.Canonical
+	page.Anchors = previous.Anchors
+
+	c.unchanged++
+	c.stats.pagesUnchanged.Inc()
+
+	return page
+}
+
+// hashLastmod derives lastmod from content changes for pages whose server
+// sends no Last-Modified header: the previous lastmod is kept while the content
+// hash matches the previous crawl, otherwise the crawl time is used.
+func (c *crawler) hashLastmod(page sitemap.Page) sitemap.Page {
+	if page.LastModified != nil && !page.LastModified.IsZero() {
+		return page
+	}
+
+	previous, ok := c.previous[page.Location]
+	if ok && previous.Hash == page.ContentHash && previous.Lastmod != nil && !previous.Lastmod.IsZero() {
+		page.LastModified = previous.Lastmod
+		return page
+	}
+
+	started := c.started
+	page.LastModified = &started
+
+	return page
+}
+
+// changefreq estimates how often a page changes from its content history,
+// falling back to -changefreq-default until enough crawls have observed it.
+func (c *crawler) changefreq(history []state.Version) sitemap.Frequency {
+	if interval, ok := state.ChangeInterval(history, c.app.changefreqMinCrawl); ok {
+		return sitemap.FrequencyFor(interval)
+	}
+	return c.app.defaultChangefreq
+}
+
+func (c *crawler) emit(page sitemap.Page) {
+	record := state.NewRecord(page)
+	if c.app.inferChangefreq {
+		record.History = state.Observe(c.previous[page.Location].History, page.ContentHash, c.started)
+		page.ChangeFrequency = c.changefreq(record.History)
+	}
+	c.current[page.Location] = record
+	c.processed++
+
+	page.Inclusion = sitemap.Inclusion{Included: true, Reason: reasonIncluded}
+	switch c.parser.Decide(page.Location) {
+	case sitemap.ScopeCrawlOnly:
+		page = exclude(page, reasonNoList)
+	case sitemap.ScopeSkip:
+		page = exclude(page, reasonExcluded)
+	}
+	if sitemap.IsBroken(page) {
+		page = exclude(page, fmt.Sprintf(reasonBroken, page.StatusCode))
+	}
+	if rule := c.app.rules.Match(page.Location); rule != nil && rule.Exclude && page.Inclusion.Included {
+		page = exclude(page, fmt.Sprintf(reasonRule, rule.Line))
+	}
+
+	if page.Inclusion.Included && c.app.mode == modeNews {
+		page = c.newsEntry(page)
+	}
+
+	c.pages = append(c.pages, c.retain(page))
+	if page.Inclusion.Included {
+		c.stats.urlsEmitted.Inc()
+		c.emitted++
+	}
+}
+
+// retain drops what none of the outputs of this crawl use from a page, as
+// every page is kept until the crawl ends.
+func (c *crawler) retain(page sitemap.Page) sitemap.Page {
+	if c.app.graphFile == "" && c.app.csvReport == "" && c.app.format != formatJSONL && c.app.priority != priorityInbound && c.app.priority != priorityPageRank {
+		page.Links = nil
+	}
+	if !c.app.reportsBrokenLinks() {
+		page.Anchors = nil
+	}
+	if c.app.csvReport == "" {
+		page.ContentType = ""
+		page.Description = ""
+		page.Canonical = ""
+		if c.app.format != formatHTML {
+			page.Title = ""
+		}
+	}
+	return page
+}
+
+// redirectUsage summarises how often each redirect status was answered, e.g.
+// "301: 4, 302: 2", so temporary redirects used for permanent moves stand out.
+func redirectUsage(chains []sitemap.RedirectChain) string {
+	usage := sitemap.RedirectUsage(chains)
+
+	statuses := make([]int, 0, len(usage))
+	for status := range usage {
+		statuses = append(statuses, status)
+	}
+	sort.Ints(statuses)
+
+	parts := make([]string, len(statuses))
+	for i, status := range statuses {
+		parts[i] = fmt.Sprintf("%d: %d", status, usage[status])
+	}
+
+	return strings.Join(parts, ", ")
+}
+
+func exclude(page sitemap.Page, reason string) sitemap.Page {
+	page.Inclusion = sitemap.Inclusion{Included: false, Reason: reason}
+	return page
+}
+
+func (c *crawler) writeSitemap() error {
+	if c.app.splitHosts != splitFiles && c.app.splitHosts != splitIndex {
+		return c.writeSitemapFile(c.app.outputFile, c.pages)
+	}
+
+	hosts, pages := pagesByHost(c.pages)
+	var entries []sitemap.IndexEntry
+	for _, host := range hosts {
+		path := hostSitemapPath(c.app.outputFile, host)
+		if err := c.writeSitemapFile(path, pages[host]); err != nil {
+			return err
+		}
+
+		entries = append(entries, sitemap.IndexEntry{
+			Location:     c.app.indexBaseURL + filepath.Base(path),
+			LastModified: latestLastmod(pages[host]),
+		})
+	}
+
+	if c.app.splitHosts != splitIndex {
+		return nil
+	}
+
+	file, err := os.Create(c.app.outputFile)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	if err := sitemap.WriteSitemapIndex(file, c.app.stylesheet, entries); err != nil {
+		return err
+	}
+
+	return file.Close()
+}
+
+// pagesByHost groups the pages listed in the sitemap by host, returning the
+// hosts in sorted order.
+func pagesByHost(pages []sitemap.Page) ([]string, map[string][]sitemap.Page) {
+	var hosts []string
+	grouped := make(map[string][]sitemap.Page)
+	for _, page := range pages {
+		if !page.Inclusion.Included {
+			continue
+		}
+
+		pageUrl, err := url.Parse(page.Location)
+		if err != nil {
+			continue
+		}
+
+		host := pageUrl.Hostname()
+		if _, ok := grouped[host]; !ok {
+			hosts = append(hosts, host)
+		}
+		grouped[host] = append(grouped[host], page)
+	}
+
+	sort.Strings(hosts)
+	return hosts, grouped
+}
+
+// hostSitemapPath names the sitemap of host after the output file, e.g.
+// sitemap-blog.example.com.xml for sitemap.xml.
+func hostSitemapPath(output, host string) string {
+	ext := filepath.Ext(output)
+	return strings.TrimSuffix(output, ext) + "-" + host + ext
+}
+
+func latestLastmod(pages []sitemap.Page) *time.Time {
+	var latest *time.Time
+	for _, page := range pages {
+		if page.LastModified != nil && !page.LastModified.IsZero() && (latest == nil || page.LastModified.After(*latest)) {
+			latest = page.LastModified
+		}
+	}
+	return latest
+}
+
+func (c *crawler) writeSitemapFile(path string, pages []sitemap.Page) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	writer := c.app.newWriter(file)
+	if c.app.sortOrder != sitemap.SortNone {
+		writer = sitemap.NewSortedWriter(writer, c.app.sortOrder, c.app.sortMemory<<20)
+	}
+
+	for _, page := range pages {
+		if !page.Inclusion.Included {
+			continue
+		}
+		if err := writer.Write(page); err != nil && c.app.verbose {
+			fmt.Fprintf(os.Stderr, "An error occured while writing %s: %v\n", page.Location, err)
+		}
+	}
+
+	if err := writer.Close(); err != nil {
+		return err
+	}
+
+	return file.Close()
+}
+
+func (app *appEnv) openJournal() (*state.Journal, state.Checkpoint, error) {
+	if app.stateDir == "" {
+		return nil, state.Checkpoint{Seen: make(map[string]bool)}, nil
+	}
+
+	if app.resume {
+		return state.Resume(app.stateDir, app.url, app.checkpointInterval)
+	}
+
+	journal, err := state.Create(app.stateDir, app.url, app.checkpointInterval)
+	return journal, state.Checkpoint{Seen: make(map[string]bool)}, err
+}
+
+// writeStylesheet puts the bundled XSL stylesheet in the sitemap's directory.
+func (c *crawler) writeStylesheet() error {
+	path := filepath.Join(filepath.Dir(c.app.outputFile), sitemap.DefaultStylesheetName)
+	return os.WriteFile(path, sitemap.DefaultStylesheet, 0644)
+}
+
+func (c *crawler) writeCSVReport() error {
+	sitemap.CountInboundLinks(c.pages)
+
+	file, err := os.Create(c.app.csvReport)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	writer := sitemap.NewCSVWriter(file, c.app.csvColumns)
+	for _, page := range c.pages {
+		if err := writer.Write(page); err != nil {
+			return err
 		}
 	}
+
+	if err := writer.Close(); err != nil {
+		return err
+	}
+
+	return file.Close()
+}
+
+func (c *crawler) writeGraph() error {
+	graph := sitemap.BuildGraph(c.pages, c.app.graphDedupe)
+
+	file, err := os.Create(c.app.graphFile)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	if c.app.graphFormat == graphGraphML {
+		err = graph.WriteGraphML(file)
+	} else {
+		err = graph.WriteDOT(file)
+	}
+	if err != nil {
+		return err
+	}
+
+	return file.Close()
+}
+
+// writeBrokenLinks writes the broken link report, as JSON when the report file
+// ends in .json and as plain text otherwise.
+func (c *crawler) writeBrokenLinks(broken []sitemap.BrokenLink) error {
+	file, err := os.Create(c.app.brokenLinksFile)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	if strings.EqualFold(filepath.Ext(c.app.brokenLinksFile), ".json") {
+		err = sitemap.WriteBrokenLinksJSON(file, broken)
+	} else {
+		err = sitemap.WriteBrokenLinks(file, broken)
+	}
+	if err != nil {
+		return err
+	}
+
+	return file.Close()
 }
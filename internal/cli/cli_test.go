@@ -1,8 +1,25 @@
 package cli
 
 import (
+	"bytes"
+	"context"
+	"errors"
 	"flag"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
 	"testing"
+	"time"
+
+	"github.com/Mihai22125/oronoxyl/internal/state"
+	"github.com/Mihai22125/oronoxyl/pkg/sitemap"
+	"github.com/Mihai22125/oronoxyl/pkg/workerpool"
 )
 
 func TestFromArgs(t *testing.T) {
@@ -41,6 +58,67 @@ func TestValidate(t *testing.T) {
 		{[]string{"-url", "http://example.com", "-output-file", "example.pdf", "-parallel", "3", "-max-depth", "3"}, flag.ErrHelp},
 		{[]string{"-url", "http://example.com", "-output-file", "example.xml", "-parallel", "0", "-max-depth", "3"}, flag.ErrHelp},
 		{[]string{"-url", "http://example.com", "-output-file", "example.xml", "-parallel", "1", "-max-depth", "0"}, flag.ErrHelp},
+		{[]string{"-url", "http://example.com", "-resume"}, flag.ErrHelp},
+		{[]string{"-url", "http://example.com", "-state-dir", "state", "-resume"}, nil},
+		{[]string{"-url", "http://example.com", "-state-dir", "state", "-checkpoint-interval", "0s"}, flag.ErrHelp},
+		{[]string{"-url", "http://example.com", "-incremental"}, flag.ErrHelp},
+		{[]string{"-url", "http://example.com", "-state-dir", "state", "-incremental"}, nil},
+		{[]string{"-url", "http://example.com", "-content-lastmod"}, flag.ErrHelp},
+		{[]string{"-url", "http://example.com", "-infer-changefreq"}, flag.ErrHelp},
+		{[]string{"-url", "http://example.com", "-state-dir", "state", "-infer-changefreq", "-changefreq-default", "weekly"}, nil},
+		{[]string{"-url", "http://example.com", "-state-dir", "state", "-infer-changefreq", "-changefreq-default", "always"}, nil},
+		{[]string{"-url", "http://example.com", "-changefreq-default", "weekly"}, flag.ErrHelp},
+		{[]string{"-url", "http://example.com", "-state-dir", "state", "-infer-changefreq", "-changefreq-default", "sometimes"}, flag.ErrHelp},
+		{[]string{"-url", "http://example.com", "-changefreq-min-crawls", "1"}, flag.ErrHelp},
+		{[]string{"-url", "http://example.com", "-lastmod-sources", "jsonld,header"}, nil},
+		{[]string{"-url", "http://example.com", "-lastmod-sources", "header,guess"}, flag.ErrHelp},
+		{[]string{"-url", "http://example.com", "-images", "-max-images", "0"}, flag.ErrHelp},
+		{[]string{"-url", "http://example.com", "-mode", "news"}, flag.ErrHelp},
+		{[]string{"-url", "http://example.com", "-mode", "news", "-news-name", "Example Times", "-news-language", "en"}, nil},
+		{[]string{"-url", "http://example.com", "-mode", "video"}, flag.ErrHelp},
+		{[]string{"-url", "http://example.com", "-format", "txt", "-output-file", "sitemap.txt"}, nil},
+		{[]string{"-url", "http://example.com", "-format", "txt", "-output-file", "sitemap.xml"}, flag.ErrHelp},
+		{[]string{"-url", "http://example.com", "-format", "jsonl", "-output-file", "crawl.jsonl"}, nil},
+		{[]string{"-url", "http://example.com", "-format", "csv", "-output-file", "crawl.csv"}, flag.ErrHelp},
+		{[]string{"-url", "http://example.com", "-format", "html", "-output-file", "sitemap.html"}, nil},
+		{[]string{"-url", "http://example.com", "-format", "html", "-output-file", "sitemap.html", "-html-template", "missing.tmpl"}, flag.ErrHelp},
+		{[]string{"-url", "http://example.com", "-html-template", "sitemap.tmpl"}, flag.ErrHelp},
+		{[]string{"-url", "http://example.com", "-csv-report", "crawl.csv"}, nil},
+		{[]string{"-url", "http://example.com", "-hosts", "www"}, nil},
+		{[]string{"-url", "http://example.com", "-max-pages", "100", "-max-duration", "30m", "-max-bytes", "1048576"}, nil},
+		{[]string{"-url", "http://example.com", "-max-pages", "-1"}, flag.ErrHelp},
+		{[]string{"-url", "http://example.com", "-priority", "pagerank", "-priority-floor", "0.2"}, nil},
+		{[]string{"-url", "http://example.com", "-priority", "depth", "-priority-table", "1:1,2:0.7"}, nil},
+		{[]string{"-url", "http://example.com", "-priority", "inbound", "-priority-table", "1:1"}, flag.ErrHelp},
+		{[]string{"-url", "http://example.com", "-priority-table", "1:2"}, flag.ErrHelp},
+		{[]string{"-url", "http://example.com", "-priority", "random"}, flag.ErrHelp},
+		{[]string{"-url", "http://example.com", "-priority-floor", "1.5"}, flag.ErrHelp},
+		{[]string{"-url", "http://example.com", "-trap-max-variants", "0", "-trap-max-repeats", "3"}, nil},
+		{[]string{"-url", "http://example.com", "-trap-max-length", "-1"}, flag.ErrHelp},
+		{[]string{"-url", "http://example.com", "-max-duration", "-1s"}, flag.ErrHelp},
+		{[]string{"-url", "http://example.com", "-documents", "-skip-extensions", "php,.asp"}, nil},
+		{[]string{"-url", "http://example.com", "-hosts", "domain", "-domain", "example.com", "-split-hosts", "index"}, nil},
+		{[]string{"-url", "http://example.com", "-hosts", "allowlist", "-allow-hosts", "docs.example.org", "-split-hosts", "files"}, nil},
+		{[]string{"-url", "http://example.com", "-hosts", "allowlist"}, flag.ErrHelp},
+		{[]string{"-url", "http://example.com", "-allow-hosts", "docs.example.org"}, flag.ErrHelp},
+		{[]string{"-url", "http://example.com", "-domain", "example.com"}, flag.ErrHelp},
+		{[]string{"-url", "http://example.com", "-hosts", "everything"}, flag.ErrHelp},
+		{[]string{"-url", "http://example.com", "-split-hosts", "dirs"}, flag.ErrHelp},
+		{[]string{"-url", "http://example.com", "-split-hosts", "index", "-format", "txt", "-output-file", "sitemap.txt"}, flag.ErrHelp},
+		{[]string{"-url", "http://example.com", "-split-hosts", "index", "-index-base-url", "/sitemaps/"}, flag.ErrHelp},
+		{[]string{"-url", "http://example.com", "-exclude", "/admin*", "-exclude", "re:^/cart", "-no-list", "/search?q=*", "-include", "/*"}, nil},
+		{[]string{"-url", "http://example.com", "-exclude", "re:(admin"}, flag.ErrHelp},
+		{[]string{"-url", "http://example.com", "-sort", "priority", "-sort-memory", "8"}, nil},
+		{[]string{"-url", "http://example.com", "-sort", "size"}, flag.ErrHelp},
+		{[]string{"-url", "http://example.com", "-sort", "loc", "-sort-memory", "0"}, flag.ErrHelp},
+		{[]string{"-url", "http://example.com", "-stylesheet", "/sitemap.xsl"}, nil},
+		{[]string{"-url", "http://example.com", "-bundle-stylesheet"}, nil},
+		{[]string{"-url", "http://example.com", "-format", "txt", "-output-file", "sitemap.txt", "-stylesheet", "/sitemap.xsl"}, flag.ErrHelp},
+		{[]string{"-url", "http://example.com", "-graph", "links.dot"}, nil},
+		{[]string{"-url", "http://example.com", "-graph", "links.GraphML", "-graph-dedupe"}, nil},
+		{[]string{"-url", "http://example.com", "-graph", "links.png"}, flag.ErrHelp},
+		{[]string{"-url", "http://example.com", "-csv-report", "crawl.csv", "-csv-columns", "url,status"}, nil},
+		{[]string{"-url", "http://example.com", "-csv-report", "crawl.csv", "-csv-columns", "url,colour"}, flag.ErrHelp},
 	}
 
 	for _, test := range testData {
@@ -52,7 +130,7 @@ func TestValidate(t *testing.T) {
 }
 
 func TestGenerateJob(t *testing.T) {
-	job := generateJob(PageJob{Url: "http://example.com", Depth: 1})
+	job := generateJob(new(sitemap.Parser), PageJob{Url: "http://example.com", Depth: 1})
 	args := job.Args.(PageJob)
 
 	if args.Url != "http://example.com" {
@@ -63,3 +141,747 @@ func TestGenerateJob(t *testing.T) {
 	}
 
 }
+
+func TestProcessPage_Redirect(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/" {
+			http.Redirect(w, r, "/home", http.StatusMovedPermanently)
+			return
+		}
+		fmt.Fprint(w, `<html><body>Home</body></html>`)
+	}))
+	defer server.Close()
+
+	page, err := processPage(context.Background(), new(sitemap.Parser), PageJob{Url: server.URL + "/", Depth: 1})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	if page.Location != server.URL+"/home" || page.Depth != 1 {
+		t.Errorf("Expected the redirected start page at depth 1, got %s at depth %d", page.Location, page.Depth)
+	}
+}
+
+func TestPageError(t *testing.T) {
+	err := &pageError{job: PageJob{Url: "http://example.com/missing", Depth: 2}, err: sitemap.ErrNotSameHost}
+
+	if !errors.Is(err, sitemap.ErrNotSameHost) {
+		t.Errorf("Expected wrapped error %v", sitemap.ErrNotSameHost)
+	}
+	if err.Error() != "http://example.com/missing: not same host" {
+		t.Errorf("Expected error message with URL, got %s", err.Error())
+	}
+}
+
+func TestStatusClass(t *testing.T) {
+	testData := []struct {
+		code     int
+		expected string
+	}{
+		{200, "2xx"},
+		{301, "3xx"},
+		{404, "4xx"},
+		{503, "5xx"},
+		{0, "unknown"},
+	}
+
+	for _, test := range testData {
+		if got := statusClass(test.code); got != test.expected {
+			t.Errorf("Expected %s, got %s", test.expected, got)
+		}
+	}
+}
+
+func TestErrorKind(t *testing.T) {
+	testData := []struct {
+		err      error
+		expected string
+	}{
+		{sitemap.ErrNotSameHost, "redirect"},
+		{&sitemap.RedirectError{Chain: sitemap.RedirectChain{Err: sitemap.ErrRedirectLoop}}, "redirect"},
+		{&url.Error{Op: "Get", URL: "http://example.com", Err: errors.New("connection refused")}, "network"},
+		{errors.New("boom"), "other"},
+	}
+
+	for _, test := range testData {
+		if got := errorKind(test.err); got != test.expected {
+			t.Errorf("Expected %s, got %s", test.expected, got)
+		}
+	}
+}
+
+func TestCrawlerReuse(t *testing.T) {
+	lastmod := time.Date(1994, 11, 15, 8, 12, 31, 0, time.UTC)
+	c := &crawler{
+		stats: newCrawlMetrics(),
+		previous: state.Index{
+			"http://example.com/a": {URL: "http://example.com/a", Lastmod: &lastmod, Links: []string{"http://example.com/b"}},
+		},
+	}
+
+	page := c.reuse(sitemap.Page{Location: "http://example.com/a", Depth: 2, NotModified: true})
+
+	if len(page.Links) != 1 || page.Links[0] != "http://example.com/b" {
+		t.Errorf("Expected previous links, got %v", page.Links)
+	}
+	if page.LastModified == nil || !page.LastModified.Equal(lastmod) {
+		t.Errorf("Expected previous lastmod %v, got %v", lastmod, page.LastModified)
+	}
+	if page.Depth != 2 {
+		t.Errorf("Expected depth 2, got %d", page.Depth)
+	}
+	if c.unchanged != 1 {
+		t.Errorf("Expected 1 unchanged page, got %d", c.unchanged)
+	}
+}
+
+func TestCrawlerHashLastmod(t *testing.T) {
+	previousLastmod := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	headerLastmod := time.Date(1994, 11, 15, 8, 12, 31, 0, time.UTC)
+	started := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+
+	c := &crawler{
+		started: started,
+		previous: state.Index{
+			"http://example.com/same":    {URL: "http://example.com/same", Hash: "abc", Lastmod: &previousLastmod},
+			"http://example.com/changed": {URL: "http://example.com/changed", Hash: "abc", Lastmod: &previousLastmod},
+		},
+	}
+
+	testData := []struct {
+		page     sitemap.Page
+		expected time.Time
+	}{
+		{sitemap.Page{Location: "http://example.com/same", ContentHash: "abc", LastModified: &time.Time{}}, previousLastmod},
+		{sitemap.Page{Location: "http://example.com/changed", ContentHash: "def", LastModified: &time.Time{}}, started},
+		{sitemap.Page{Location: "http://example.com/new", ContentHash: "abc"}, started},
+		{sitemap.Page{Location: "http://example.com/same", ContentHash: "abc", LastModified: &headerLastmod}, headerLastmod},
+	}
+
+	for _, test := range testData {
+		page := c.hashLastmod(test.page)
+		if page.LastModified == nil || !page.LastModified.Equal(test.expected) {
+			t.Errorf("Expected lastmod %v for %s, got %v", test.expected, test.page.Location, page.LastModified)
+		}
+	}
+}
+
+func TestSplitList(t *testing.T) {
+	items := splitList(" cdn.example.com, ,img.example.net,")
+	if len(items) != 2 || items[0] != "cdn.example.com" || items[1] != "img.example.net" {
+		t.Errorf("Expected [cdn.example.com img.example.net], got %v", items)
+	}
+}
+
+func TestCrawlerNewsEntry(t *testing.T) {
+	started := time.Date(2024, 5, 3, 0, 0, 0, 0, time.UTC)
+	recent := started.Add(-24 * time.Hour)
+	old := started.Add(-72 * time.Hour)
+
+	c := &crawler{
+		app:     &appEnv{newsName: "Example Times", newsLanguage: "en"},
+		started: started,
+	}
+
+	included := sitemap.Inclusion{Included: true, Reason: reasonIncluded}
+	page := c.newsEntry(sitemap.Page{Location: "http://example.com/a", Title: "A", Published: &recent, Inclusion: included})
+	if page.Inclusion != included {
+		t.Fatalf("Expected recent article to be included, got %+v", page.Inclusion)
+	}
+	if page.News == nil || page.News.Publication.Name != "Example Times" || page.News.Title != "A" || !page.News.PublicationDate.Equal(recent) {
+		t.Errorf("Expected news entry, got %+v", page.News)
+	}
+
+	testData := []struct {
+		page   sitemap.Page
+		reason string
+	}{
+		{sitemap.Page{Location: "http://example.com/b", Published: &old, Inclusion: included}, reasonTooOld},
+		{sitemap.Page{Location: "http://example.com/c", Inclusion: included}, reasonNoPublicationDate},
+	}
+
+	for _, test := range testData {
+		page := c.newsEntry(test.page)
+		if page.Inclusion.Included || page.Inclusion.Reason != test.reason {
+			t.Errorf("Expected %s to be excluded with reason %q, got %+v", test.page.Location, test.reason, page.Inclusion)
+		}
+	}
+
+	c.emitted = sitemap.MaxNewsURLs
+	page = c.newsEntry(sitemap.Page{Location: "http://example.com/d", Published: &recent, Inclusion: included})
+	if page.Inclusion.Included || page.Inclusion.Reason != reasonNewsLimit {
+		t.Errorf("Expected article beyond the URL limit to be excluded, got %+v", page.Inclusion)
+	}
+}
+
+func TestCrawlerWriteSitemap(t *testing.T) {
+	output := filepath.Join(t.TempDir(), "sitemap.xml")
+	c := &crawler{
+		app: &appEnv{outputFile: output},
+		pages: []sitemap.Page{
+			{Location: "http://example.com", Priority: 1, Inclusion: sitemap.Inclusion{Included: true}},
+			{Location: "http://example.com/old", Priority: 1, Inclusion: sitemap.Inclusion{Reason: reasonTooOld}},
+		},
+	}
+
+	if err := c.writeSitemap(); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	data, err := os.ReadFile(output)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	expected := sitemap.URLSetStart() + "\n <url>\n   <loc>http://example.com</loc>\n   <priority>1</priority>\n </url>\n</urlset>"
+	if string(data) != expected {
+		t.Errorf("Expected %q, got %q", expected, string(data))
+	}
+}
+
+func TestCrawlerWriteCSVReport(t *testing.T) {
+	output := filepath.Join(t.TempDir(), "report.csv")
+	c := &crawler{
+		app: &appEnv{csvReport: output, csvColumns: []string{"url", "inbound_links", "included", "reason"}},
+		pages: []sitemap.Page{
+			{Location: "http://example.com", Links: []string{"http://example.com/a"}, Inclusion: sitemap.Inclusion{Included: true, Reason: reasonIncluded}},
+			{Location: "http://example.com/a", Inclusion: sitemap.Inclusion{Reason: reasonTooOld}},
+		},
+	}
+
+	if err := c.writeCSVReport(); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	data, err := os.ReadFile(output)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	expected := "url,inbound_links,included,reason\n" +
+		"http://example.com,0,true,included\n" +
+		"http://example.com/a,1,false,published more than 48 hours ago\n"
+	if string(data) != expected {
+		t.Errorf("Expected %q, got %q", expected, string(data))
+	}
+}
+
+func TestCrawlerWriteGraph(t *testing.T) {
+	output := filepath.Join(t.TempDir(), "links.dot")
+	c := &crawler{
+		app: &appEnv{graphFile: output, graphFormat: graphDOT, graphDedupe: true},
+		pages: []sitemap.Page{
+			{Location: "http://example.com", Depth: 1, StatusCode: 200, Links: []string{"http://example.com/a", "http://example.com/a"}},
+			{Location: "http://example.com/a", Depth: 2, StatusCode: 404},
+		},
+	}
+
+	if err := c.writeGraph(); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	data, err := os.ReadFile(output)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	expected := "digraph sitemap {\n" +
+		"  \"http://example.com\" [depth=1, status=200];\n" +
+		"  \"http://example.com/a\" [depth=2, status=404];\n" +
+		"  \"http://example.com\" -> \"http://example.com/a\" [weight=2];\n" +
+		"}\n"
+	if string(data) != expected {
+		t.Errorf("Expected %q, got %q", expected, string(data))
+	}
+}
+
+func TestCrawlerWriteStylesheet(t *testing.T) {
+	dir := t.TempDir()
+	app := &appEnv{}
+	if err := app.fromArgs([]string{"-url", "http://example.com", "-output-file", filepath.Join(dir, "sitemap.xml"), "-bundle-stylesheet"}); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	c := &crawler{
+		app:   app,
+		pages: []sitemap.Page{{Location: "http://example.com", Inclusion: sitemap.Inclusion{Included: true}}},
+	}
+	if err := c.writeSitemap(); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if err := c.writeStylesheet(); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	data, err := os.ReadFile(app.outputFile)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if !strings.HasPrefix(string(data), sitemap.StylesheetPI(sitemap.DefaultStylesheetName)+"\n<urlset") {
+		t.Errorf("Expected sitemap to reference the bundled stylesheet, got %q", string(data))
+	}
+
+	xsl, err := os.ReadFile(filepath.Join(dir, sitemap.DefaultStylesheetName))
+	if err != nil {
+		t.Fatalf("Expected stylesheet next to the sitemap, got %v", err)
+	}
+	if !bytes.Equal(xsl, sitemap.DefaultStylesheet) {
+		t.Errorf("Expected bundled stylesheet contents")
+	}
+}
+
+func TestCrawlerScope(t *testing.T) {
+	app := &appEnv{}
+	if err := app.fromArgs([]string{"-url", "http://example.com", "-max-depth", "5", "-exclude", "/admin*", "-no-list", "/search*"}); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	c := &crawler{
+		app:     app,
+		parser:  app.parser(),
+		stats:   newCrawlMetrics(),
+		seen:    make(map[string]bool),
+		current: make(state.Index),
+		wp:      workerpool.New(1),
+	}
+
+	c.emit(sitemap.Page{Location: "http://example.com/search?q=x"})
+	c.emit(sitemap.Page{Location: "http://example.com/about"})
+	if len(c.pages) != 2 || c.pages[0].Inclusion.Included || c.pages[0].Inclusion.Reason != reasonNoList || !c.pages[1].Inclusion.Included {
+		t.Errorf("Expected search page crawled but not listed, got %+v", c.pages)
+	}
+	if c.emitted != 1 {
+		t.Errorf("Expected 1 emitted page, got %d", c.emitted)
+	}
+
+	if err := c.expand(sitemap.Page{Location: "http://example.com", Depth: 1, Links: []string{"http://example.com/admin/users", "http://example.com/search?q=y"}}); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if c.seen["http://example.com/admin/users"] || !c.seen["http://example.com/search?q=y"] {
+		t.Errorf("Expected only in-scope links to be scheduled, got %v", c.seen)
+	}
+}
+
+func TestCrawlerWriteSitemap_SplitHosts(t *testing.T) {
+	dir := t.TempDir()
+	lastmod := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	included := sitemap.Inclusion{Included: true}
+	c := &crawler{
+		app: &appEnv{outputFile: filepath.Join(dir, "sitemap.xml"), splitHosts: splitIndex, indexBaseURL: "https://example.com/sitemaps/"},
+		pages: []sitemap.Page{
+			{Location: "https://www.example.com/", Inclusion: included},
+			{Location: "https://blog.example.com/post", LastModified: &lastmod, Inclusion: included},
+			{Location: "https://www.example.com/search", Inclusion: sitemap.Inclusion{Reason: reasonNoList}},
+		},
+	}
+
+	if err := c.writeSitemap(); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	index, err := os.ReadFile(filepath.Join(dir, "sitemap.xml"))
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	for _, expected := range []string{
+		"<loc>https://example.com/sitemaps/sitemap-blog.example.com.xml</loc>\n   <lastmod>2024-05-01T10:00:00Z</lastmod>",
+		"<loc>https://example.com/sitemaps/sitemap-www.example.com.xml</loc>\n </sitemap>",
+	} {
+		if !strings.Contains(string(index), expected) {
+			t.Errorf("Expected index to contain %q, got %q", expected, string(index))
+		}
+	}
+
+	www, err := os.ReadFile(filepath.Join(dir, "sitemap-www.example.com.xml"))
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if !strings.Contains(string(www), "https://www.example.com/") || strings.Contains(string(www), "search") || strings.Contains(string(www), "blog") {
+		t.Errorf("Expected only the listed www page, got %q", string(www))
+	}
+}
+
+func TestCrawlerBudgetHit(t *testing.T) {
+	started := time.Date(2024, 5, 3, 0, 0, 0, 0, time.UTC)
+	now := started.Add(10 * time.Minute)
+
+	testData := []struct {
+		app        appEnv
+		scheduled  int
+		downloaded int64
+		expected   string
+	}{
+		{appEnv{}, 1000, 1 << 30, ""},
+		{appEnv{maxPages: 10}, 9, 0, ""},
+		{appEnv{maxPages: 10}, 10, 0, budgetPages},
+		{appEnv{maxDuration: time.Hour}, 0, 0, ""},
+		{appEnv{maxDuration: 10 * time.Minute}, 0, 0, budgetDuration},
+		{appEnv{maxBytes: 1000}, 0, 999, ""},
+		{appEnv{maxBytes: 1000}, 0, 1000, budgetBytes},
+		{appEnv{maxPages: 10, maxBytes: 1000}, 10, 1000, budgetPages},
+	}
+
+	for _, test := range testData {
+		app := test.app
+		c := &crawler{app: &app, started: started, scheduled: test.scheduled, downloaded: test.downloaded, clock: func() time.Time { return now }}
+		if hit := c.budgetHit(); hit != test.expected {
+			t.Errorf("Expected budget %q for %+v, got %q", test.expected, test, hit)
+		}
+	}
+}
+
+func TestCrawlerExpand_Budget(t *testing.T) {
+	c := &crawler{
+		app:   &appEnv{maxDepth: 5, maxPages: 2},
+		stats: newCrawlMetrics(),
+		seen:  map[string]bool{"http://example.com": true},
+		wp:    workerpool.New(1),
+	}
+	if err := c.schedule("http://example.com", 1); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	err := c.expand(sitemap.Page{Location: "http://example.com", Depth: 1, Links: []string{"http://example.com/a", "http://example.com/b", "http://example.com/c"}})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	if c.scheduled != 2 || c.wp.GetQueueSize() != 2 || c.seen["http://example.com/b"] {
+		t.Errorf("Expected 2 scheduled pages, got %d with %d queued", c.scheduled, c.wp.GetQueueSize())
+	}
+	if c.stoppedBy != budgetPages {
+		t.Errorf("Expected crawl stopped by %s, got %q", budgetPages, c.stoppedBy)
+	}
+
+	c.processed, c.downloaded = 2, 2048
+	if expected := "Crawl ended by the -max-pages budget of 2 pages after 2 pages and 2048 bytes"; c.budgetReport() != expected {
+		t.Errorf("Expected %q, got %q", expected, c.budgetReport())
+	}
+}
+
+func TestCrawlerRetain(t *testing.T) {
+	page := sitemap.Page{
+		Location:    "http://example.com",
+		Links:       []string{"http://example.com/a"},
+		Anchors:     []sitemap.Anchor{{URL: "http://example.com/a", Text: "a"}},
+		Title:       "Home",
+		Description: "The home page",
+		Images:      []sitemap.Image{{Location: "http://example.com/a.png"}},
+	}
+
+	c := &crawler{app: &appEnv{priority: priorityDepth}}
+	kept := c.retain(page)
+	if kept.Links != nil || kept.Anchors != nil || kept.Title != "" || kept.Description != "" {
+		t.Errorf("Expected data no output uses to be dropped, got %+v", kept)
+	}
+	if len(kept.Images) != 1 {
+		t.Errorf("Expected sitemap data to be kept, got %+v", kept)
+	}
+
+	c.app = &appEnv{priority: priorityPageRank, csvReport: "crawl.csv", failOnBroken: true}
+	if kept := c.retain(page); !reflect.DeepEqual(kept, page) {
+		t.Errorf("Expected page to be kept whole, got %+v", kept)
+	}
+}
+
+func TestCrawlerExpand_Traps(t *testing.T) {
+	c := &crawler{
+		app:   &appEnv{maxDepth: 5},
+		stats: newCrawlMetrics(),
+		seen:  make(map[string]bool),
+		wp:    workerpool.New(1),
+		traps: sitemap.NewTrapDetector(sitemap.TrapLimits{MaxQueryVariants: 2}),
+	}
+
+	links := []string{"http://example.com/calendar?month=1", "http://example.com/calendar?month=2", "http://example.com/calendar?month=3"}
+	if err := c.expand(sitemap.Page{Location: "http://example.com", Depth: 1, Links: links}); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if err := c.expand(sitemap.Page{Location: "http://example.com/about", Depth: 1, Links: links}); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	if c.scheduled != 2 {
+		t.Errorf("Expected 2 scheduled pages, got %d", c.scheduled)
+	}
+	if traps := c.traps.Traps(); len(traps) != 1 || traps[0].URLs != 1 || traps[0].Template != "http://example.com/calendar?month=*" {
+		t.Errorf("Expected one calendar trap cutting off one URL, got %v", traps)
+	}
+}
+
+func TestRulesFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rules.json")
+	if err := os.WriteFile(path, []byte(`{"rules": [{"pattern": "/tags/*", "exclude": true}]}`), 0644); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	app := &appEnv{}
+	if err := app.fromArgs([]string{"-url", "http://example.com", "-rules", path}); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	c := &crawler{app: app, parser: app.parser(), stats: newCrawlMetrics(), current: make(state.Index)}
+	c.emit(sitemap.Page{Location: "http://example.com/tags/go"})
+	if len(c.pages) != 1 || c.pages[0].Inclusion.Included || c.pages[0].Inclusion.Reason != "excluded by the rule on line 1" {
+		t.Errorf("Expected page excluded by rule, got %+v", c.pages)
+	}
+
+	if err := os.WriteFile(path, []byte("{\"rules\": [\n  {\"pattern\": \"/a\"}\n]}"), 0644); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if err := app.fromArgs([]string{"-url", "http://example.com", "-rules", path}); err != flag.ErrHelp {
+		t.Errorf("Expected invalid rules file to be rejected, got %v", err)
+	}
+}
+
+func TestCrawlerInferChangefreq(t *testing.T) {
+	started := time.Date(2024, 5, 4, 0, 0, 0, 0, time.UTC)
+	history := []state.Version{
+		{Hash: "a", FirstSeen: started.AddDate(0, 0, -3), LastSeen: started.AddDate(0, 0, -3), Crawls: 1},
+		{Hash: "b", FirstSeen: started.AddDate(0, 0, -2), LastSeen: started.AddDate(0, 0, -2), Crawls: 1},
+		{Hash: "c", FirstSeen: started.AddDate(0, 0, -1), LastSeen: started.AddDate(0, 0, -1), Crawls: 1},
+	}
+
+	app := &appEnv{inferChangefreq: true, defaultChangefreq: sitemap.Monthly, changefreqMinCrawl: 3}
+	c := &crawler{
+		app:      app,
+		stats:    newCrawlMetrics(),
+		started:  started,
+		previous: state.Index{"http://example.com/news": {URL: "http://example.com/news", History: history}},
+		current:  make(state.Index),
+	}
+
+	c.emit(sitemap.Page{Location: "http://example.com/news", ContentHash: "d"})
+	c.emit(sitemap.Page{Location: "http://example.com/new", ContentHash: "a"})
+
+	if freq := c.pages[0].ChangeFrequency; freq != sitemap.Daily {
+		t.Errorf("Expected page changing every crawl to be daily, got %v", freq)
+	}
+	if freq := c.pages[1].ChangeFrequency; freq != sitemap.Monthly {
+		t.Errorf("Expected page without history to get the default, got %v", freq)
+	}
+	if history := c.current["http://example.com/news"].History; len(history) != 4 || history[3].Hash != "d" {
+		t.Errorf("Expected history to be extended, got %+v", history)
+	}
+}
+
+func TestCrawlerBrokenLinks(t *testing.T) {
+	c := &crawler{
+		app:      &appEnv{},
+		stats:    newCrawlMetrics(),
+		current:  make(state.Index),
+		failures: make(map[string]string),
+	}
+
+	job := PageJob{Url: "http://example.com/down", Depth: 2}
+	if err := c.handle(workerpool.Result{Err: &pageError{job: job, err: errors.New("connection refused")}}); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	job = PageJob{Url: "http://example.com/elsewhere", Depth: 2}
+	if err := c.handle(workerpool.Result{Err: &pageError{job: job, err: sitemap.ErrNotSameHost}}); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if len(c.failures) != 1 || c.failures["http://example.com/down"] != "connection refused" {
+		t.Errorf("Expected only the failed fetch to be recorded, got %v", c.failures)
+	}
+
+	c.emit(sitemap.Page{Location: "http://example.com/missing", StatusCode: 404})
+	if c.pages[0].Inclusion.Included || c.pages[0].Inclusion.Reason != "HTTP status 404" {
+		t.Errorf("Expected broken page to be excluded, got %+v", c.pages[0].Inclusion)
+	}
+}
+
+func TestResumeRestoresFailures(t *testing.T) {
+	dir := t.TempDir()
+	j, err := state.Create(dir, "http://example.com", time.Hour)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	j.Enqueue(state.Entry{URL: "http://example.com", Depth: 1})
+	j.Page(sitemap.Page{
+		Location: "http://example.com",
+		Depth:    1,
+		Links:    []string{"http://example.com/down"},
+		Anchors:  []sitemap.Anchor{{URL: "http://example.com/down", Text: "Down"}},
+	})
+	j.Enqueue(state.Entry{URL: "http://example.com/down", Depth: 2})
+	j.Fail("http://example.com/down", "connection refused", nil)
+	if err := j.Close(); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	var app appEnv
+	report := filepath.Join(dir, "broken.txt")
+	args := []string{"-url", "http://example.com", "-state-dir", dir, "-resume", "-broken-links", report, "-verbose=false", "-output-file", filepath.Join(dir, "sitemap.xml")}
+	if err := app.fromArgs(args); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if err := app.run(); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	data, err := os.ReadFile(report)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if !strings.Contains(string(data), "http://example.com/down\tconnection refused") {
+		t.Errorf("Expected the failure from before the interruption to be reported, got %q", data)
+	}
+}
+
+func TestCrawlerRedirects(t *testing.T) {
+	c := &crawler{
+		app:     &appEnv{},
+		stats:   newCrawlMetrics(),
+		seen:    map[string]bool{"http://example.com/": true, "http://example.com/old": true, "http://example.com/moved": true},
+		current: make(state.Index),
+	}
+
+	redirected := func(from string, status int) sitemap.Page {
+		return sitemap.Page{Location: "http://example.com/new", Redirects: []sitemap.Hop{{URL: from, Status: status}}}
+	}
+	if err := c.handle(workerpool.Result{Value: redirected("http://example.com/old", 301)}); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if err := c.handle(workerpool.Result{Value: redirected("http://example.com/moved", 302)}); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	if len(c.pages) != 1 || c.pages[0].Location != "http://example.com/new" {
+		t.Errorf("Expected the final URL to be emitted once, got %+v", c.pages)
+	}
+	if !c.seen["http://example.com/new"] {
+		t.Errorf("Expected the final URL to be marked seen")
+	}
+	if len(c.redirects) != 2 {
+		t.Errorf("Expected both redirect chains to be recorded, got %v", c.redirects)
+	}
+	if usage := redirectUsage(c.redirects); usage != "301: 1, 302: 1" {
+		t.Errorf("Expected usage by status, got %q", usage)
+	}
+}
+
+func TestContentLastmodSendsNoValidators(t *testing.T) {
+	var conditional []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("If-None-Match") != "" || r.Header.Get("If-Modified-Since") != "" {
+			conditional = append(conditional, r.URL.Path)
+		}
+		w.Header().Set("ETag", `"v1"`)
+		fmt.Fprint(w, `<html><body>Home</body></html>`)
+	}))
+	defer server.Close()
+
+	dir := t.TempDir()
+	index := state.Index{server.URL: {URL: server.URL, Validators: sitemap.Validators{ETag: `"v1"`, LastModified: "Tue, 15 Nov 1994 08:12:31 GMT"}}}
+	if err := index.Save(dir); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	var app appEnv
+	args := []string{"-url", server.URL, "-state-dir", dir, "-content-lastmod", "-verbose=false", "-output-file", filepath.Join(dir, "sitemap.xml")}
+	if err := app.fromArgs(args); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if err := app.run(); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	if len(conditional) != 0 {
+		t.Errorf("Expected no conditional requests without -incremental, got %v", conditional)
+	}
+}
+
+func TestCrawlerNewJob(t *testing.T) {
+	c := &crawler{
+		app:      &appEnv{},
+		previous: state.Index{"http://example.com/new": {URL: "http://example.com/new", Validators: sitemap.Validators{ETag: `"v1"`}}},
+	}
+
+	if job := c.newJob("http://example.com/old", 2); job.Validators != nil {
+		t.Errorf("Expected no validators without -incremental")
+	}
+
+	c.app.incremental = true
+	job := c.newJob("http://example.com/old", 2)
+	if job.Validators == nil || job.Validators("http://example.com/new").ETag != `"v1"` || job.Validators("http://example.com/old").ETag != "" {
+		t.Errorf("Expected validators looked up by the URL requested")
+	}
+}
+
+func TestBudgetSkipsQueuedPages(t *testing.T) {
+	var mu sync.Mutex
+	var requests int
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		requests++
+		mu.Unlock()
+
+		if r.URL.Path == "/" {
+			for i := 0; i < 50; i++ {
+				// absolute links, as relative ones lose the test server's port
+				fmt.Fprintf(w, `<a href="%s/p%d">p</a>`, server.URL, i)
+			}
+			return
+		}
+		time.Sleep(20 * time.Millisecond)
+		fmt.Fprint(w, strings.Repeat("x", 1000))
+	}))
+	defer server.Close()
+
+	var app appEnv
+	args := []string{"-url", server.URL, "-parallel", "1", "-max-bytes", "3000", "-verbose=false", "-output-file", filepath.Join(t.TempDir(), "sitemap.xml")}
+	if err := app.fromArgs(args); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if err := app.run(); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	// The start page queues 50 pages; the budget runs out with the first of
+	// them, leaving at most the one fetch already started to finish.
+	mu.Lock()
+	defer mu.Unlock()
+	if requests > 3 {
+		t.Errorf("Expected queued pages to be skipped once the budget ran out, got %d requests", requests)
+	}
+}
+
+func TestBudgetFetchesScheduledPages(t *testing.T) {
+	var mu sync.Mutex
+	var requests int
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		requests++
+		mu.Unlock()
+
+		if r.URL.Path == "/" {
+			for i := 0; i < 50; i++ {
+				fmt.Fprintf(w, `<a href="%s/p%d">p</a>`, server.URL, i)
+			}
+			return
+		}
+		time.Sleep(5 * time.Millisecond)
+		fmt.Fprint(w, "page")
+	}))
+	defer server.Close()
+
+	var app appEnv
+	args := []string{"-url", server.URL, "-parallel", "1", "-max-pages", "10", "-verbose=false", "-output-file", filepath.Join(t.TempDir(), "sitemap.xml")}
+	if err := app.fromArgs(args); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if err := app.run(); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	// The budget is used up while the start page's links are enqueued, but the
+	// 10 pages scheduled within it are all fetched.
+	mu.Lock()
+	defer mu.Unlock()
+	if requests != 10 {
+		t.Errorf("Expected 10 pages fetched within -max-pages, got %d requests", requests)
+	}
+}
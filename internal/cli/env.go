@@ -3,17 +3,139 @@ package cli
 import (
 	"flag"
 	"fmt"
+	"html/template"
+	"io"
 	"net/url"
 	"os"
 	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/Mihai22125/oronoxyl/pkg/sitemap"
+)
+
+const (
+	formatXML   = "xml"
+	formatTXT   = "txt"
+	formatJSONL = "jsonl"
+	formatHTML  = "html"
+)
+
+const (
+	priorityDepth    = "depth"
+	priorityInbound  = "inbound"
+	priorityPageRank = "pagerank"
 )
 
+const (
+	splitNone  = "none"
+	splitFiles = "files"
+	splitIndex = "index"
+)
+
+const (
+	graphDOT     = "dot"
+	graphGraphML = "graphml"
+)
+
+var graphFormats = map[string]string{
+	".dot":     graphDOT,
+	".gv":      graphDOT,
+	".graphml": graphGraphML,
+}
+
+var formatExtensions = map[string][]string{
+	formatXML:   {".xml"},
+	formatTXT:   {".txt"},
+	formatJSONL: {".jsonl", ".ndjson"},
+	formatHTML:  {".html", ".htm"},
+}
+
 type appEnv struct {
 	url             string
 	parallelWorkers int
 	outputFile      string
 	maxDepth        int
 	verbose         bool
+	metricsAddr     string
+
+	stateDir           string
+	resume             bool
+	checkpointInterval time.Duration
+	incremental        bool
+	contentLastmod     bool
+	inferChangefreq    bool
+	changefreqDefault  string
+	defaultChangefreq  sitemap.Frequency
+	changefreqMinCrawl int
+	lastmodSourceList  string
+	lastmodSources     []sitemap.LastmodSource
+
+	images        bool
+	maxImages     int
+	imageHostList string
+	videos        bool
+
+	mode         string
+	newsName     string
+	newsLanguage string
+
+	hreflang bool
+
+	format string
+
+	htmlTemplateFile string
+	htmlTemplate     *template.Template
+
+	stylesheet       string
+	bundleStylesheet bool
+
+	includes []string
+	excludes []string
+	noList   []string
+	scope    sitemap.ScopeRules
+
+	maxPages    int
+	maxDuration time.Duration
+	maxBytes    int64
+
+	trapLimits sitemap.TrapLimits
+
+	maxRedirects    int
+	redirectMaxHops int
+
+	rulesFile string
+	rules     sitemap.Rules
+
+	priority          string
+	priorityTableList string
+	priorityFloor     float64
+	priorityStrategy  sitemap.PriorityStrategy
+
+	documents         bool
+	skipExtensionList string
+
+	hostPolicy    string
+	allowHostList string
+	domain        string
+	hostScope     *sitemap.HostScope
+	splitHosts    string
+	indexBaseURL  string
+
+	sortOrderName string
+	sortOrder     sitemap.SortOrder
+	sortMemory    int
+
+	csvReport     string
+	csvColumnList string
+	csvColumns    []string
+
+	graphFile   string
+	graphFormat string
+	graphDedupe bool
+
+	brokenLinksFile string
+	failOnBroken    bool
 }
 
 func (app *appEnv) fromArgs(args []string) error {
@@ -23,6 +145,61 @@ func (app *appEnv) fromArgs(args []string) error {
 	fl.StringVar(&app.outputFile, "output-file", "./temp.xml", "output file path")
 	fl.IntVar(&app.maxDepth, "max-depth", 3, "max depth of url navigation recursion")
 	fl.BoolVar(&app.verbose, "verbose", true, "display detailed processing information")
+	fl.StringVar(&app.metricsAddr, "metrics-addr", "", "address to serve Prometheus metrics on, e.g. :9090 (disabled if empty)")
+	fl.StringVar(&app.stateDir, "state-dir", "", "directory to checkpoint crawl progress into (disabled if empty)")
+	fl.BoolVar(&app.resume, "resume", false, "continue the interrupted crawl recorded in -state-dir")
+	fl.DurationVar(&app.checkpointInterval, "checkpoint-interval", 5*time.Second, "how often checkpoints are flushed to disk")
+	fl.BoolVar(&app.incremental, "incremental", false, "send conditional requests using validators saved in -state-dir by the previous crawl")
+	fl.BoolVar(&app.contentLastmod, "content-lastmod", false, "derive lastmod from content changes when the server sends no Last-Modified header")
+	fl.BoolVar(&app.inferChangefreq, "infer-changefreq", false, "derive changefreq from how often page content changed across crawls saved in -state-dir")
+	fl.StringVar(&app.changefreqDefault, "changefreq-default", "", "changefreq for pages without enough history for -infer-changefreq (omitted if empty)")
+	fl.IntVar(&app.changefreqMinCrawl, "changefreq-min-crawls", 3, "crawls of a page needed before -infer-changefreq estimates its changefreq")
+	fl.StringVar(&app.lastmodSourceList, "lastmod-sources", "header,meta,jsonld,time", "comma separated lastmod sources, in order of preference")
+	fl.BoolVar(&app.images, "images", false, "add image sitemap entries for images found on each page")
+	fl.IntVar(&app.maxImages, "max-images", sitemap.DefaultMaxImages, "maximum number of images listed per page")
+	fl.StringVar(&app.imageHostList, "image-hosts", "", "comma separated extra hosts (e.g. CDNs) images may be served from, *.example.com matches subdomains")
+	fl.BoolVar(&app.videos, "videos", false, "add video sitemap entries for videos found on each page")
+	fl.StringVar(&app.mode, "mode", modeStandard, "sitemap type to generate: standard or news")
+	fl.StringVar(&app.newsName, "news-name", "", "publication name for -mode=news")
+	fl.StringVar(&app.newsLanguage, "news-language", "", "publication language (ISO 639 code) for -mode=news")
+	fl.BoolVar(&app.hreflang, "hreflang", false, "add hreflang alternates (xhtml:link) and report inconsistent clusters")
+	fl.StringVar(&app.format, "format", formatXML, "output format: xml, txt, jsonl or html")
+	fl.StringVar(&app.htmlTemplateFile, "html-template", "", "custom html/template file for -format=html (built-in template if empty)")
+	fl.StringVar(&app.stylesheet, "stylesheet", "", "reference this XSL stylesheet from the XML sitemap (disabled if empty)")
+	fl.BoolVar(&app.bundleStylesheet, "bundle-stylesheet", false, "write the bundled XSL stylesheet next to the sitemap and reference it")
+	// Repeatable flags start empty like every other flag starts at its default.
+	app.includes, app.excludes, app.noList = nil, nil, nil
+	fl.Var((*listFlag)(&app.includes), "include", "only crawl URLs whose path and query match this glob, or regex with a re: prefix (repeatable)")
+	fl.Var((*listFlag)(&app.excludes), "exclude", "neither crawl nor list URLs matching this glob or re: regex (repeatable)")
+	fl.Var((*listFlag)(&app.noList), "no-list", "crawl URLs matching this glob or re: regex for links but leave them out of the sitemap (repeatable)")
+	fl.IntVar(&app.maxPages, "max-pages", 0, "stop enqueueing pages once this many were queued (unlimited if 0)")
+	fl.DurationVar(&app.maxDuration, "max-duration", 0, "stop enqueueing pages once the crawl has run this long, e.g. 30m (unlimited if 0)")
+	fl.Int64Var(&app.maxBytes, "max-bytes", 0, "stop enqueueing pages once this many bytes were downloaded (unlimited if 0)")
+	fl.IntVar(&app.trapLimits.MaxRepeats, "trap-max-repeats", sitemap.DefaultTrapLimits.MaxRepeats, "skip URLs repeating one path segment more often than this (disabled if 0)")
+	fl.IntVar(&app.trapLimits.MaxPathDepth, "trap-max-path-depth", sitemap.DefaultTrapLimits.MaxPathDepth, "skip URLs with more path segments than this (disabled if 0)")
+	fl.IntVar(&app.trapLimits.MaxURLLength, "trap-max-length", sitemap.DefaultTrapLimits.MaxURLLength, "skip URLs longer than this (disabled if 0)")
+	fl.IntVar(&app.trapLimits.MaxQueryVariants, "trap-max-variants", sitemap.DefaultTrapLimits.MaxQueryVariants, "crawl at most this many query strings per path and set of parameters (disabled if 0)")
+	fl.IntVar(&app.maxRedirects, "max-redirects", sitemap.DefaultMaxRedirects, "redirects followed from a URL before giving up on it")
+	fl.IntVar(&app.redirectMaxHops, "redirect-max-hops", 1, "report redirect chains of more than this many redirects (disabled if 0)")
+	fl.StringVar(&app.rulesFile, "rules", "", "JSON file of per URL pattern priority, changefreq, exclude and lastmod overrides (disabled if empty)")
+	fl.StringVar(&app.priority, "priority", priorityDepth, "how priorities are assigned: depth, inbound (inbound link count) or pagerank")
+	fl.StringVar(&app.priorityTableList, "priority-table", "", "comma separated depth:priority pairs for -priority=depth, e.g. 1:1.0,2:0.8 (built-in table if empty)")
+	fl.Float64Var(&app.priorityFloor, "priority-floor", sitemap.DefaultPriorityFloor, "lowest priority assigned to any page")
+	fl.BoolVar(&app.documents, "documents", false, "crawl and list PDFs and other documents search engines index ("+strings.Join(sitemap.DocumentExtensions, " ")+")")
+	fl.StringVar(&app.skipExtensionList, "skip-extensions", "", "comma separated extra file extensions to neither crawl nor list")
+	fl.StringVar(&app.hostPolicy, "hosts", string(sitemap.HostExact), "hosts to crawl: exact, www (with the www alias), domain (all subdomains) or allowlist (with -allow-hosts)")
+	fl.StringVar(&app.allowHostList, "allow-hosts", "", "comma separated extra hosts crawled with -hosts=allowlist")
+	fl.StringVar(&app.domain, "domain", "", "registrable domain whose subdomains are crawled with -hosts=domain (derived from -url if empty)")
+	fl.StringVar(&app.splitHosts, "split-hosts", splitNone, "none (one sitemap), files (one sitemap per host) or index (per host sitemaps and a sitemap index at -output-file)")
+	fl.StringVar(&app.indexBaseURL, "index-base-url", "", "URL the per host sitemaps are published under, for -split-hosts=index (root of -url if empty)")
+	fl.StringVar(&app.sortOrderName, "sort", "", "sort sitemap entries by loc, priority (then loc) or depth (then loc) (completion order if empty)")
+	fl.IntVar(&app.sortMemory, "sort-memory", sitemap.DefaultSortMemory>>20, "MiB of entries to sort in memory before spilling to a temp file")
+	fl.StringVar(&app.csvReport, "csv-report", "", "write a CSV crawl report to this path (disabled if empty)")
+	fl.StringVar(&app.csvColumnList, "csv-columns", strings.Join(sitemap.CSVColumns, ","), "comma separated columns of the CSV crawl report")
+	fl.StringVar(&app.graphFile, "graph", "", "write the internal link graph to this path, as DOT (.dot, .gv) or GraphML (.graphml) (disabled if empty)")
+	fl.BoolVar(&app.graphDedupe, "graph-dedupe", false, "merge repeated links between two pages into one edge weighted by their count")
+	fl.StringVar(&app.brokenLinksFile, "broken-links", "", "write crawled links answering with an error or failing to load, and the pages linking to them, to this path as text or JSON (.json) (disabled if empty)")
+	fl.BoolVar(&app.failOnBroken, "fail-on-broken", false, "exit with status 3 when broken links were found")
 	fl.Parse(args)
 
 	if err := app.validate(); err != nil {
@@ -40,9 +217,15 @@ func (app *appEnv) validate() error {
 		return flag.ErrHelp
 	}
 
+	extensions, ok := formatExtensions[app.format]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "Unknown output format %q\n", app.format)
+		return flag.ErrHelp
+	}
+
 	fileExtension := filepath.Ext(app.outputFile)
-	if fileExtension != ".xml" {
-		fmt.Fprintln(os.Stderr, "File extension ins't equal to .xml")
+	if !contains(extensions, fileExtension) {
+		fmt.Fprintf(os.Stderr, "File extension ins't one of %s\n", strings.Join(extensions, ", "))
 		return flag.ErrHelp
 	}
 
@@ -56,5 +239,314 @@ func (app *appEnv) validate() error {
 		return flag.ErrHelp
 	}
 
+	if app.resume && app.stateDir == "" {
+		fmt.Fprintln(os.Stderr, "-resume requires -state-dir")
+		return flag.ErrHelp
+	}
+
+	if app.incremental && app.stateDir == "" {
+		fmt.Fprintln(os.Stderr, "-incremental requires -state-dir")
+		return flag.ErrHelp
+	}
+
+	if app.contentLastmod && app.stateDir == "" {
+		fmt.Fprintln(os.Stderr, "-content-lastmod requires -state-dir")
+		return flag.ErrHelp
+	}
+
+	if app.inferChangefreq && app.stateDir == "" {
+		fmt.Fprintln(os.Stderr, "-infer-changefreq requires -state-dir")
+		return flag.ErrHelp
+	}
+
+	if app.changefreqDefault != "" && !app.inferChangefreq {
+		fmt.Fprintln(os.Stderr, "-changefreq-default requires -infer-changefreq")
+		return flag.ErrHelp
+	}
+
+	app.defaultChangefreq = sitemap.None
+	if app.changefreqDefault != "" {
+		freq, err := sitemap.ParseFrequency(app.changefreqDefault)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return flag.ErrHelp
+		}
+		app.defaultChangefreq = freq
+	}
+
+	if app.changefreqMinCrawl < 2 {
+		fmt.Fprintln(os.Stderr, "-changefreq-min-crawls must be at least 2")
+		return flag.ErrHelp
+	}
+
+	sources, err := sitemap.ParseLastmodSources(app.lastmodSourceList)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return flag.ErrHelp
+	}
+	app.lastmodSources = sources
+
+	if app.maxImages < 1 {
+		fmt.Fprintln(os.Stderr, "Maximum images per page cant be smaller than 1")
+		return flag.ErrHelp
+	}
+
+	switch app.mode {
+	case modeStandard:
+	case modeNews:
+		if app.newsName == "" || app.newsLanguage == "" {
+			fmt.Fprintln(os.Stderr, "-mode=news requires -news-name and -news-language")
+			return flag.ErrHelp
+		}
+	default:
+		fmt.Fprintf(os.Stderr, "Unknown mode %q\n", app.mode)
+		return flag.ErrHelp
+	}
+
+	if app.htmlTemplateFile != "" {
+		if app.format != formatHTML {
+			fmt.Fprintln(os.Stderr, "-html-template requires -format=html")
+			return flag.ErrHelp
+		}
+		tmpl, err := sitemap.ParseHTMLTemplate(app.htmlTemplateFile)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return flag.ErrHelp
+		}
+		app.htmlTemplate = tmpl
+	}
+
+	if app.graphFile != "" {
+		format, ok := graphFormats[strings.ToLower(filepath.Ext(app.graphFile))]
+		if !ok {
+			fmt.Fprintf(os.Stderr, "Unknown graph format for %s, use .dot, .gv or .graphml\n", app.graphFile)
+			return flag.ErrHelp
+		}
+		app.graphFormat = format
+	}
+
+	if (app.stylesheet != "" || app.bundleStylesheet) && app.format != formatXML {
+		fmt.Fprintln(os.Stderr, "-stylesheet and -bundle-stylesheet require -format=xml")
+		return flag.ErrHelp
+	}
+	if app.bundleStylesheet && app.stylesheet == "" {
+		app.stylesheet = sitemap.DefaultStylesheetName
+	}
+
+	if limits := app.trapLimits; limits.MaxRepeats < 0 || limits.MaxPathDepth < 0 || limits.MaxURLLength < 0 || limits.MaxQueryVariants < 0 {
+		fmt.Fprintln(os.Stderr, "crawler trap limits can't be negative")
+		return flag.ErrHelp
+	}
+
+	app.rules = nil
+	if app.rulesFile != "" {
+		if app.rules, err = sitemap.LoadRules(app.rulesFile); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return flag.ErrHelp
+		}
+	}
+
+	if app.priorityFloor < 0 || app.priorityFloor > 1 {
+		fmt.Fprintln(os.Stderr, "-priority-floor must be between 0 and 1")
+		return flag.ErrHelp
+	}
+	if app.priorityTableList != "" && app.priority != priorityDepth {
+		fmt.Fprintln(os.Stderr, "-priority-table requires -priority=depth")
+		return flag.ErrHelp
+	}
+	switch app.priority {
+	case priorityDepth:
+		var table map[int]float64
+		if app.priorityTableList != "" {
+			if table, err = sitemap.ParsePriorityTable(app.priorityTableList); err != nil {
+				fmt.Fprintln(os.Stderr, err)
+				return flag.ErrHelp
+			}
+		}
+		app.priorityStrategy = sitemap.DepthPriority{Table: table, Floor: app.priorityFloor}
+	case priorityInbound:
+		app.priorityStrategy = sitemap.InboundLinkPriority{Floor: app.priorityFloor}
+	case priorityPageRank:
+		app.priorityStrategy = sitemap.PageRankPriority{Floor: app.priorityFloor}
+	default:
+		fmt.Fprintf(os.Stderr, "Unknown priority strategy %q\n", app.priority)
+		return flag.ErrHelp
+	}
+
+	if app.maxPages < 0 || app.maxDuration < 0 || app.maxBytes < 0 {
+		fmt.Fprintln(os.Stderr, "-max-pages, -max-duration and -max-bytes can't be negative")
+		return flag.ErrHelp
+	}
+
+	if app.maxRedirects < 1 || app.redirectMaxHops < 0 {
+		fmt.Fprintln(os.Stderr, "-max-redirects must be at least 1 and -redirect-max-hops can't be negative")
+		return flag.ErrHelp
+	}
+
+	policy, err := sitemap.ParseHostPolicy(app.hostPolicy)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return flag.ErrHelp
+	}
+	allowHosts := splitList(app.allowHostList)
+	if (policy == sitemap.HostAllowlist) != (len(allowHosts) > 0) {
+		fmt.Fprintln(os.Stderr, "-allow-hosts is required by, and only valid with, -hosts=allowlist")
+		return flag.ErrHelp
+	}
+	if app.domain != "" && policy != sitemap.HostDomain {
+		fmt.Fprintln(os.Stderr, "-domain requires -hosts=domain")
+		return flag.ErrHelp
+	}
+	app.hostScope = &sitemap.HostScope{Policy: policy, Start: u.Hostname(), Domain: app.domain, Hosts: allowHosts}
+
+	if !contains([]string{splitNone, splitFiles, splitIndex}, app.splitHosts) {
+		fmt.Fprintf(os.Stderr, "Unknown -split-hosts mode %q\n", app.splitHosts)
+		return flag.ErrHelp
+	}
+	if app.splitHosts == splitIndex && app.format != formatXML {
+		fmt.Fprintln(os.Stderr, "-split-hosts=index requires -format=xml")
+		return flag.ErrHelp
+	}
+	if app.indexBaseURL == "" {
+		app.indexBaseURL = u.Scheme + "://" + u.Host + "/"
+	}
+	if base, err := url.Parse(app.indexBaseURL); err != nil || base.Scheme == "" || base.Host == "" {
+		fmt.Fprintln(os.Stderr, "the provided -index-base-url is not valid")
+		return flag.ErrHelp
+	}
+
+	app.scope = sitemap.ScopeRules{}
+	for _, rule := range []struct {
+		patterns []string
+		scoped   *[]*sitemap.Pattern
+	}{
+		{app.includes, &app.scope.Include},
+		{app.excludes, &app.scope.Exclude},
+		{app.noList, &app.scope.NoList},
+	} {
+		for _, raw := range rule.patterns {
+			pattern, err := sitemap.ParsePattern(raw)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Invalid pattern %q: %v\n", raw, err)
+				return flag.ErrHelp
+			}
+			*rule.scoped = append(*rule.scoped, pattern)
+		}
+	}
+
+	sortOrder, err := sitemap.ParseSortOrder(app.sortOrderName)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return flag.ErrHelp
+	}
+	app.sortOrder = sortOrder
+
+	if app.sortMemory < 1 {
+		fmt.Fprintln(os.Stderr, "-sort-memory must be at least 1")
+		return flag.ErrHelp
+	}
+
+	if app.csvReport != "" {
+		columns, err := sitemap.ParseCSVColumns(app.csvColumnList)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return flag.ErrHelp
+		}
+		app.csvColumns = columns
+	}
+
+	if app.checkpointInterval <= 0 {
+		fmt.Fprintln(os.Stderr, "Checkpoint interval must be positive")
+		return flag.ErrHelp
+	}
+
+	return nil
+}
+
+func (app *appEnv) parser() *sitemap.Parser {
+	return &sitemap.Parser{
+		LastmodSources: app.lastmodSources,
+		Images:         app.images,
+		MaxImages:      app.maxImages,
+		ImageHosts:     splitList(app.imageHostList),
+		Videos:         app.videos,
+		Hreflang:       app.hreflang,
+		Scope:          app.scope.Decide,
+		Hosts:          app.hostScope,
+		Filter:         app.linkFilter(),
+		Rules:          app.rules,
+		MaxRedirects:   app.maxRedirects,
+	}
+}
+
+func (app *appEnv) linkFilter() *sitemap.LinkFilter {
+	filter := sitemap.NewLinkFilter(app.documents)
+	filter.Skip(splitList(app.skipExtensionList)...)
+	return filter
+}
+
+func (app *appEnv) extensions() []sitemap.Extension {
+	var extensions []sitemap.Extension
+	if app.images {
+		extensions = append(extensions, sitemap.ImageExtension)
+	}
+	if app.videos {
+		extensions = append(extensions, sitemap.VideoExtension)
+	}
+	if app.mode == modeNews {
+		extensions = append(extensions, sitemap.NewsExtension)
+	}
+	if app.hreflang {
+		extensions = append(extensions, sitemap.XHTMLExtension)
+	}
+	return extensions
+}
+
+func (app *appEnv) newWriter(w io.Writer) sitemap.Writer {
+	switch app.format {
+	case formatTXT:
+		return sitemap.NewTextWriter(w)
+	case formatJSONL:
+		return sitemap.NewJSONLWriter(w)
+	case formatHTML:
+		return sitemap.NewHTMLWriter(w, app.htmlTemplate)
+	}
+	writer := sitemap.NewXMLWriter(w, app.extensions()...)
+	writer.Stylesheet = app.stylesheet
+	return writer
+}
+
+func (app *appEnv) reportsBrokenLinks() bool {
+	return app.brokenLinksFile != "" || app.failOnBroken
+}
+
+func contains(list []string, item string) bool {
+	for _, candidate := range list {
+		if candidate == item {
+			return true
+		}
+	}
+	return false
+}
+
+// listFlag collects the values of a flag that may be given several times.
+type listFlag []string
+
+func (l *listFlag) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *listFlag) Set(value string) error {
+	*l = append(*l, value)
 	return nil
 }
+
+func splitList(list string) []string {
+	var items []string
+	for _, item := range strings.Split(list, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
@@ -2,36 +2,59 @@ package cli
 
 import (
 	"context"
+	"errors"
+	"fmt"
 
 	"github.com/Mihai22125/oronoxyl/pkg/sitemap"
 	"github.com/Mihai22125/oronoxyl/pkg/workerpool"
 )
 
 type PageJob struct {
-	Url   string
-	Depth int
+	Url        string
+	Depth      int
+	Validators sitemap.ValidatorsFunc
+	// Stop is closed once a crawl budget is used up, so queued jobs are
+	// skipped instead of fetched.
+	Stop <-chan struct{}
 }
 
-func generateJob(page PageJob) workerpool.Job {
+var errBudgetExhausted = errors.New("crawl budget exhausted")
+
+func generateJob(parser *sitemap.Parser, page PageJob) workerpool.Job {
+	wrapper := func(ctx context.Context, pageJob interface{}) (interface{}, error) {
+		return processPage(ctx, parser, pageJob.(PageJob))
+	}
 	return workerpool.Job{Descriptor: workerpool.JobDescriptor{ID: 1}, ExecFn: wrapper, Args: page}
 }
 
-func wrapper(ctx context.Context, pageJob interface{}) (interface{}, error) {
-	return processPage(ctx, pageJob.(PageJob))
-}
+func processPage(ctx context.Context, parser *sitemap.Parser, pageJob PageJob) (sitemap.Page, error) {
+	select {
+	case <-pageJob.Stop:
+		return sitemap.Page{}, &pageError{job: pageJob, err: errBudgetExhausted}
+	default:
+	}
 
-func processPage(ctx context.Context, pageJob PageJob) (sitemap.Page, error) {
-	page, err := sitemap.ParsePage(pageJob.Url)
+	page, err := parser.ParseConditional(pageJob.Url, pageJob.Validators)
 	if err != nil {
-		return sitemap.Page{}, err
+		return sitemap.Page{}, &pageError{job: pageJob, err: err}
 	}
 
+	// A redirected page keeps the depth of the URL linked to, as a redirect
+	// isn't a link.
 	page.Depth = pageJob.Depth
-	if page.Location != pageJob.Url {
-		page.Depth = pageJob.Depth + 1
-	}
-
-	page.Priority = sitemap.PriorityMap[page.Depth]
 
 	return page, nil
 }
+
+type pageError struct {
+	job PageJob
+	err error
+}
+
+func (e *pageError) Error() string {
+	return fmt.Sprintf("%s: %v", e.job.Url, e.err)
+}
+
+func (e *pageError) Unwrap() error {
+	return e.err
+}
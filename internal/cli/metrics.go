@@ -0,0 +1,77 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+
+	"github.com/Mihai22125/oronoxyl/pkg/metrics"
+	"github.com/Mihai22125/oronoxyl/pkg/sitemap"
+)
+
+type crawlMetrics struct {
+	registry        *metrics.Registry
+	pagesFetched    *metrics.Counter
+	bytesDownloaded *metrics.Counter
+	fetchLatency    *metrics.Histogram
+	queueDepth      *metrics.Gauge
+	urlsEmitted     *metrics.Counter
+	pagesUnchanged  *metrics.Counter
+	errors          *metrics.Counter
+}
+
+func newCrawlMetrics() *crawlMetrics {
+	m := &crawlMetrics{
+		registry:        metrics.NewRegistry(),
+		pagesFetched:    metrics.NewCounter("oronoxyl_pages_fetched_total", "Pages fetched, by HTTP status class.", "class"),
+		bytesDownloaded: metrics.NewCounter("oronoxyl_bytes_downloaded_total", "Response body bytes downloaded."),
+		fetchLatency:    metrics.NewHistogram("oronoxyl_fetch_duration_seconds", "Time spent fetching and parsing a page.", metrics.DefaultBuckets),
+		queueDepth:      metrics.NewGauge("oronoxyl_queue_depth", "Jobs waiting in the worker pool queue."),
+		urlsEmitted:     metrics.NewCounter("oronoxyl_urls_emitted_total", "URLs written to the sitemap."),
+		pagesUnchanged:  metrics.NewCounter("oronoxyl_pages_unchanged_total", "Pages the server reported as not modified."),
+		errors:          metrics.NewCounter("oronoxyl_errors_total", "Failed page fetches, by kind.", "kind"),
+	}
+
+	m.registry.Register(m.pagesFetched, m.bytesDownloaded, m.fetchLatency, m.queueDepth, m.urlsEmitted, m.pagesUnchanged, m.errors)
+
+	return m
+}
+
+func (m *crawlMetrics) observePage(page sitemap.Page) {
+	m.pagesFetched.Inc(statusClass(page.StatusCode))
+	m.bytesDownloaded.Add(float64(page.Size))
+	m.fetchLatency.Observe(page.FetchDuration.Seconds())
+}
+
+func (m *crawlMetrics) observeError(err error) {
+	m.errors.Inc(errorKind(err))
+}
+
+func statusClass(code int) string {
+	if code < 100 || code > 599 {
+		return "unknown"
+	}
+	return fmt.Sprintf("%dxx", code/100)
+}
+
+func errorKind(err error) string {
+	var netErr net.Error
+	var urlErr *url.Error
+	var redirectErr *sitemap.RedirectError
+
+	switch {
+	case errors.Is(err, context.Canceled):
+		return "canceled"
+	case errors.Is(err, context.DeadlineExceeded):
+		return "timeout"
+	case errors.As(err, &netErr) && netErr.Timeout():
+		return "timeout"
+	case errors.Is(err, sitemap.ErrNotSameHost), errors.As(err, &redirectErr):
+		return "redirect"
+	case errors.As(err, &urlErr):
+		return "network"
+	}
+	return "other"
+}
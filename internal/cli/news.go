@@ -0,0 +1,44 @@
+package cli
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/Mihai22125/oronoxyl/pkg/sitemap"
+)
+
+const (
+	modeStandard = "standard"
+	modeNews     = "news"
+)
+
+// newsEntry decides whether a page belongs in a news sitemap: it must carry a
+// publication date from the last 48 hours and the sitemap must not be full.
+func (c *crawler) newsEntry(page sitemap.Page) sitemap.Page {
+	if page.Published == nil {
+		return exclude(page, reasonNoPublicationDate)
+	}
+
+	if c.started.Sub(*page.Published) > sitemap.NewsMaxAge {
+		return exclude(page, reasonTooOld)
+	}
+
+	if c.emitted >= sitemap.MaxNewsURLs {
+		if !c.newsFull && c.app.verbose {
+			fmt.Fprintf(os.Stderr, "\nNews sitemap limit of %d URLs reached, further articles are skipped\n", sitemap.MaxNewsURLs)
+		}
+		c.newsFull = true
+		return exclude(page, reasonNewsLimit)
+	}
+
+	page.News = &sitemap.News{
+		Publication: sitemap.Publication{
+			Name:     c.app.newsName,
+			Language: c.app.newsLanguage,
+		},
+		PublicationDate: *page.Published,
+		Title:           page.Title,
+	}
+
+	return page
+}
@@ -0,0 +1,75 @@
+package state
+
+import (
+	"math"
+	"time"
+)
+
+// HistoryLimit is how many versions of a URL's content the index remembers.
+const HistoryLimit = 20
+
+// Version is one content hash of a URL and the crawls that observed it.
+type Version struct {
+	Hash      string
+	FirstSeen time.Time
+	LastSeen  time.Time
+	Crawls    int
+}
+
+// Observe returns history extended with the content hash seen by a crawl at
+// the given time. Consecutive crawls of unchanged content share one version,
+// and only the newest HistoryLimit versions are kept.
+func Observe(history []Version, hash string, at time.Time) []Version {
+	if hash == "" {
+		return history
+	}
+
+	observed := make([]Version, len(history), len(history)+1)
+	copy(observed, history)
+
+	if n := len(observed); n > 0 && observed[n-1].Hash == hash {
+		if at.After(observed[n-1].LastSeen) {
+			observed[n-1].LastSeen = at
+			observed[n-1].Crawls++
+		}
+		return observed
+	}
+
+	observed = append(observed, Version{Hash: hash, FirstSeen: at, LastSeen: at, Crawls: 1})
+	if len(observed) > HistoryLimit {
+		observed = observed[len(observed)-HistoryLimit:]
+	}
+
+	return observed
+}
+
+// ChangeInterval estimates the mean time between content changes. Crawls only
+// see whether content changed since the previous one, not how often, so the
+// change rate is estimated as -ln((n-x+0.5)/(n+0.5)) per crawl interval for x
+// changes over n intervals (Cho and Garcia-Molina), which allows for missed
+// changes. Content that never changed gets the longest interval. ok is false
+// until history holds at least minCrawls crawls covering some time.
+func ChangeInterval(history []Version, minCrawls int) (interval time.Duration, ok bool) {
+	if len(history) == 0 {
+		return 0, false
+	}
+
+	crawls := 0
+	for _, version := range history {
+		crawls += version.Crawls
+	}
+
+	span := history[len(history)-1].LastSeen.Sub(history[0].FirstSeen)
+	if crawls < minCrawls || span <= 0 {
+		return 0, false
+	}
+
+	n := float64(crawls - 1)
+	changes := float64(len(history) - 1)
+	rate := -math.Log((n - changes + 0.5) / (n + 0.5))
+	if rate <= 0 {
+		return math.MaxInt64, true
+	}
+
+	return time.Duration(float64(span) / n / rate), true
+}
@@ -0,0 +1,134 @@
+package state
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/Mihai22125/oronoxyl/pkg/sitemap"
+)
+
+const indexFile = "index.jsonl"
+
+// Record is what a finished crawl remembers about a URL for the next run.
+type Record struct {
+	URL        string
+	Validators sitemap.Validators
+	Lastmod    *time.Time          `json:",omitempty"`
+	Links      []string            `json:",omitempty"`
+	Hash       string              `json:",omitempty"`
+	Images     []sitemap.Image     `json:",omitempty"`
+	Videos     []sitemap.Video     `json:",omitempty"`
+	Title      string              `json:",omitempty"`
+	Published  *time.Time          `json:",omitempty"`
+	Alternates []sitemap.Alternate `json:",omitempty"`
+
+	ContentType string           `json:",omitempty"`
+	Description string           `json:",omitempty"`
+	Canonical   string           `json:",omitempty"`
+	Anchors     []sitemap.Anchor `json:",omitempty"`
+
+	History []Version `json:",omitempty"`
+}
+
+type Index map[string]Record
+
+func NewRecord(page sitemap.Page) Record {
+	return Record{
+		URL:        page.Location,
+		Validators: page.Validators,
+		Lastmod:    page.LastModified,
+		Links:      page.Links,
+		Hash:       page.ContentHash,
+		Images:     page.Images,
+		Videos:     page.Videos,
+		Title:      page.Title,
+		Published:  page.Published,
+		Alternates: page.Alternates,
+
+		ContentType: page.ContentType,
+		Description: page.Description,
+		Canonical:   page.Canonical,
+		Anchors:     page.Anchors,
+	}
+}
+
+func LoadIndex(dir string) (Index, error) {
+	index := make(Index)
+
+	file, err := os.Open(filepath.Join(dir, indexFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return index, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+
+	for line := 1; scanner.Scan(); line++ {
+		var rec Record
+		if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", indexFile, line, err)
+		}
+		index[rec.URL] = rec
+	}
+
+	return index, scanner.Err()
+}
+
+// Carry adds the records of previous for URLs missing from idx, so that URLs a
+// crawl didn't fetch keep their validators and content history.
+func (idx Index) Carry(previous Index) {
+	for url, record := range previous {
+		if _, ok := idx[url]; !ok {
+			idx[url] = record
+		}
+	}
+}
+
+// Save replaces the index in dir atomically, so a crash while saving keeps the
+// previous run's data intact.
+func (idx Index) Save(dir string) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(dir, indexFile+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	urls := make([]string, 0, len(idx))
+	for url := range idx {
+		urls = append(urls, url)
+	}
+	sort.Strings(urls)
+
+	writer := bufio.NewWriter(tmp)
+	encoder := json.NewEncoder(writer)
+	for _, url := range urls {
+		if err := encoder.Encode(idx[url]); err != nil {
+			tmp.Close()
+			return err
+		}
+	}
+
+	if err := writer.Flush(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), filepath.Join(dir, indexFile))
+}
@@ -0,0 +1,270 @@
+package state
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/Mihai22125/oronoxyl/pkg/sitemap"
+)
+
+const journalFile = "journal.jsonl"
+
+const (
+	opStart   = "start"
+	opEnqueue = "enqueue"
+	opPage    = "page"
+	opFail    = "fail"
+	opDone    = "done"
+)
+
+var ErrURLMismatch = errors.New("state directory belongs to a crawl of a different url")
+
+type Entry struct {
+	URL   string
+	Depth int
+}
+
+// Checkpoint is the progress of a crawl replayed from its journal. Failures
+// maps URLs that failed to the error they failed with, and Redirects holds the
+// redirect chains followed so far.
+type Checkpoint struct {
+	URL       string
+	Seen      map[string]bool
+	Pages     []sitemap.Page
+	Frontier  []Entry
+	Failures  map[string]string
+	Redirects []sitemap.RedirectChain
+	Complete  bool
+}
+
+type record struct {
+	Op    string                 `json:"op"`
+	URL   string                 `json:"url,omitempty"`
+	Depth int                    `json:"depth,omitempty"`
+	Page  *sitemap.Page          `json:"page,omitempty"`
+	Error string                 `json:"error,omitempty"`
+	Chain *sitemap.RedirectChain `json:"chain,omitempty"`
+}
+
+// Journal is an append-only log of crawl progress. A nil *Journal discards
+// everything written to it, which is how crawls without a state directory run.
+type Journal struct {
+	file      *os.File
+	writer    *bufio.Writer
+	interval  time.Duration
+	lastFlush time.Time
+}
+
+func Create(dir, url string, interval time.Duration) (*Journal, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	file, err := os.Create(filepath.Join(dir, journalFile))
+	if err != nil {
+		return nil, err
+	}
+
+	j := newJournal(file, interval)
+	if err := j.write(record{Op: opStart, URL: url}); err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	return j, nil
+}
+
+// Resume replays the journal in dir and reopens it for appending. A missing
+// or completed journal yields a fresh one and an empty checkpoint.
+func Resume(dir, url string, interval time.Duration) (*Journal, Checkpoint, error) {
+	file, err := os.OpenFile(filepath.Join(dir, journalFile), os.O_RDWR, 0644)
+	if errors.Is(err, os.ErrNotExist) {
+		j, err := Create(dir, url, interval)
+		return j, Checkpoint{URL: url, Seen: make(map[string]bool)}, err
+	}
+	if err != nil {
+		return nil, Checkpoint{}, err
+	}
+
+	cp, offset, err := Replay(file)
+	if err != nil {
+		file.Close()
+		return nil, Checkpoint{}, err
+	}
+
+	if cp.URL != url {
+		file.Close()
+		return nil, Checkpoint{}, fmt.Errorf("%w: %s", ErrURLMismatch, cp.URL)
+	}
+
+	if cp.Complete {
+		file.Close()
+		j, err := Create(dir, url, interval)
+		return j, Checkpoint{URL: url, Seen: make(map[string]bool)}, err
+	}
+
+	// drop a partially written trailing record before appending
+	if err := file.Truncate(offset); err != nil {
+		file.Close()
+		return nil, Checkpoint{}, err
+	}
+	if _, err := file.Seek(offset, io.SeekStart); err != nil {
+		file.Close()
+		return nil, Checkpoint{}, err
+	}
+
+	return newJournal(file, interval), cp, nil
+}
+
+// Replay rebuilds a checkpoint from a journal. It also returns the offset just
+// past the last complete record, so a torn final write can be discarded.
+func Replay(r io.Reader) (Checkpoint, int64, error) {
+	cp := Checkpoint{Seen: make(map[string]bool), Failures: make(map[string]string)}
+
+	var pending []Entry
+	finished := make(map[string]bool)
+	var offset int64
+
+	reader := bufio.NewReader(r)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return Checkpoint{}, 0, err
+		}
+
+		var rec record
+		if err := json.Unmarshal(bytes.TrimSpace(line), &rec); err != nil {
+			if _, peekErr := reader.Peek(1); peekErr == io.EOF {
+				break
+			}
+			return Checkpoint{}, 0, fmt.Errorf("corrupt journal at offset %d: %w", offset, err)
+		}
+		offset += int64(len(line))
+
+		switch rec.Op {
+		case opStart:
+			cp.URL = rec.URL
+		case opEnqueue:
+			cp.Seen[rec.URL] = true
+			pending = append(pending, Entry{URL: rec.URL, Depth: rec.Depth})
+		case opPage:
+			if rec.Page != nil {
+				cp.Pages = append(cp.Pages, *rec.Page)
+				finished[rec.Page.Location] = true
+				if len(rec.Page.Redirects) > 0 {
+					finished[rec.Page.Redirects[0].URL] = true
+					cp.Redirects = append(cp.Redirects, sitemap.RedirectChain{Hops: rec.Page.Redirects, Final: rec.Page.Location})
+				}
+			}
+		case opFail:
+			finished[rec.URL] = true
+			if rec.Error != "" {
+				cp.Failures[rec.URL] = rec.Error
+			}
+			if rec.Chain != nil {
+				cp.Redirects = append(cp.Redirects, *rec.Chain)
+			}
+		case opDone:
+			cp.Complete = true
+		}
+	}
+
+	for _, entry := range pending {
+		if !finished[entry.URL] {
+			cp.Frontier = append(cp.Frontier, entry)
+		}
+	}
+
+	return cp, offset, nil
+}
+
+func newJournal(file *os.File, interval time.Duration) *Journal {
+	return &Journal{
+		file:      file,
+		writer:    bufio.NewWriter(file),
+		interval:  interval,
+		lastFlush: time.Now(),
+	}
+}
+
+func (j *Journal) Enqueue(entry Entry) error {
+	if j == nil {
+		return nil
+	}
+	return j.write(record{Op: opEnqueue, URL: entry.URL, Depth: entry.Depth})
+}
+
+func (j *Journal) Page(page sitemap.Page) error {
+	if j == nil {
+		return nil
+	}
+	return j.write(record{Op: opPage, Page: &page})
+}
+
+// Fail records that url produced no page, with the error it failed with, if
+// any, and the redirect chain followed from it, if any.
+func (j *Journal) Fail(url, reason string, chain *sitemap.RedirectChain) error {
+	if j == nil {
+		return nil
+	}
+	return j.write(record{Op: opFail, URL: url, Error: reason, Chain: chain})
+}
+
+func (j *Journal) Done() error {
+	if j == nil {
+		return nil
+	}
+	if err := j.write(record{Op: opDone}); err != nil {
+		return err
+	}
+	return j.Flush()
+}
+
+func (j *Journal) Flush() error {
+	if j == nil {
+		return nil
+	}
+	j.lastFlush = time.Now()
+	if err := j.writer.Flush(); err != nil {
+		return err
+	}
+	return j.file.Sync()
+}
+
+func (j *Journal) Close() error {
+	if j == nil {
+		return nil
+	}
+	if err := j.Flush(); err != nil {
+		j.file.Close()
+		return err
+	}
+	return j.file.Close()
+}
+
+func (j *Journal) write(rec record) error {
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+
+	if _, err := j.writer.Write(append(data, '\n')); err != nil {
+		return err
+	}
+
+	if time.Since(j.lastFlush) >= j.interval {
+		return j.Flush()
+	}
+
+	return nil
+}
@@ -0,0 +1,344 @@
+package state
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Mihai22125/oronoxyl/pkg/sitemap"
+)
+
+func writeTestJournal(t *testing.T, dir string) {
+	j, err := Create(dir, "http://example.com", time.Hour)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	j.Enqueue(Entry{URL: "http://example.com", Depth: 1})
+	j.Page(sitemap.Page{Location: "http://example.com", Depth: 1, Links: []string{"http://example.com/a", "http://example.com/b"}})
+	j.Enqueue(Entry{URL: "http://example.com/a", Depth: 2})
+	j.Enqueue(Entry{URL: "http://example.com/b", Depth: 2})
+	j.Fail("http://example.com/a", "connection refused", nil)
+
+	if err := j.Close(); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+}
+
+func TestReplay(t *testing.T) {
+	input := strings.Join([]string{
+		`{"op":"start","url":"http://example.com"}`,
+		`{"op":"enqueue","url":"http://example.com","depth":1}`,
+		`{"op":"page","page":{"Location":"http://example.com","Depth":1}}`,
+		`{"op":"enqueue","url":"http://example.com/a","depth":2}`,
+		`{"op":"enq`,
+	}, "\n")
+
+	cp, offset, err := Replay(strings.NewReader(input))
+	if err != nil {
+		t.Errorf("Expected no error, got %v", err)
+	}
+	if cp.URL != "http://example.com" {
+		t.Errorf("Expected URL http://example.com, got %s", cp.URL)
+	}
+	if len(cp.Pages) != 1 || cp.Pages[0].Location != "http://example.com" {
+		t.Errorf("Expected one replayed page, got %v", cp.Pages)
+	}
+	if len(cp.Frontier) != 1 || cp.Frontier[0] != (Entry{URL: "http://example.com/a", Depth: 2}) {
+		t.Errorf("Expected frontier with /a, got %v", cp.Frontier)
+	}
+	if !cp.Seen["http://example.com/a"] {
+		t.Errorf("Expected /a to be seen")
+	}
+	if expected := int64(len(input) - len(`{"op":"enq`)); offset != expected {
+		t.Errorf("Expected offset %d, got %d", expected, offset)
+	}
+}
+
+func TestReplay_Corrupt(t *testing.T) {
+	input := "{\"op\":\"start\",\"url\":\"http://example.com\"}\nnot json\n{\"op\":\"done\"}\n"
+
+	_, _, err := Replay(strings.NewReader(input))
+	if err == nil {
+		t.Errorf("Expected corrupt journal error, got nil")
+	}
+}
+
+func TestResume(t *testing.T) {
+	dir := t.TempDir()
+	writeTestJournal(t, dir)
+
+	j, cp, err := Resume(dir, "http://example.com", time.Hour)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	defer j.Close()
+
+	if len(cp.Pages) != 1 {
+		t.Errorf("Expected 1 page, got %d", len(cp.Pages))
+	}
+	if len(cp.Pages[0].Links) != 2 {
+		t.Errorf("Expected page links to be restored, got %v", cp.Pages[0].Links)
+	}
+	if len(cp.Frontier) != 1 || cp.Frontier[0].URL != "http://example.com/b" {
+		t.Errorf("Expected frontier with /b, got %v", cp.Frontier)
+	}
+}
+
+func TestResume_Complete(t *testing.T) {
+	dir := t.TempDir()
+	writeTestJournal(t, dir)
+
+	j, _, _ := Resume(dir, "http://example.com", time.Hour)
+	j.Done()
+	j.Close()
+
+	j, cp, err := Resume(dir, "http://example.com", time.Hour)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	j.Close()
+
+	if len(cp.Pages) != 0 || len(cp.Frontier) != 0 {
+		t.Errorf("Expected empty checkpoint after completed crawl, got %v", cp)
+	}
+}
+
+func TestResume_Missing(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "state")
+
+	j, cp, err := Resume(dir, "http://example.com", time.Hour)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	j.Close()
+
+	if cp.Seen == nil {
+		t.Errorf("Expected initialised seen set")
+	}
+	if _, err := os.Stat(filepath.Join(dir, journalFile)); err != nil {
+		t.Errorf("Expected journal to be created, got %v", err)
+	}
+}
+
+func TestResume_URLMismatch(t *testing.T) {
+	dir := t.TempDir()
+	writeTestJournal(t, dir)
+
+	_, _, err := Resume(dir, "http://other.com", time.Hour)
+	if !errors.Is(err, ErrURLMismatch) {
+		t.Errorf("Expected error %v, got %v", ErrURLMismatch, err)
+	}
+}
+
+func TestJournal_Nil(t *testing.T) {
+	var j *Journal
+	if err := j.Enqueue(Entry{URL: "http://example.com"}); err != nil {
+		t.Errorf("Expected no error, got %v", err)
+	}
+	if err := j.Close(); err != nil {
+		t.Errorf("Expected no error, got %v", err)
+	}
+}
+
+func TestIndex_SaveLoad(t *testing.T) {
+	dir := t.TempDir()
+	lastmod := time.Date(1994, 11, 15, 8, 12, 31, 0, time.UTC)
+
+	index := Index{
+		"http://example.com": NewRecord(sitemap.Page{
+			Location:     "http://example.com",
+			LastModified: &lastmod,
+			Links:        []string{"http://example.com/a"},
+			Validators:   sitemap.Validators{ETag: `"v1"`},
+		}),
+	}
+
+	if err := index.Save(dir); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	loaded, err := LoadIndex(dir)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	rec, ok := loaded["http://example.com"]
+	if !ok {
+		t.Fatalf("Expected record for http://example.com")
+	}
+	if rec.Validators.ETag != `"v1"` {
+		t.Errorf("Expected ETag %q, got %q", `"v1"`, rec.Validators.ETag)
+	}
+	if rec.Lastmod == nil || !rec.Lastmod.Equal(lastmod) {
+		t.Errorf("Expected lastmod %v, got %v", lastmod, rec.Lastmod)
+	}
+	if len(rec.Links) != 1 {
+		t.Errorf("Expected 1 link, got %v", rec.Links)
+	}
+}
+
+func TestIndex_Carry(t *testing.T) {
+	at := time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+	previous := Index{
+		"http://example.com/":  {URL: "http://example.com/", Hash: "old"},
+		"http://example.com/a": {URL: "http://example.com/a", Hash: "a", History: []Version{{Hash: "a", FirstSeen: at, LastSeen: at, Crawls: 3}}},
+	}
+	current := Index{"http://example.com/": {URL: "http://example.com/", Hash: "new"}}
+
+	current.Carry(previous)
+
+	if current["http://example.com/"].Hash != "new" {
+		t.Errorf("Expected the record fetched by this crawl to be kept, got %+v", current["http://example.com/"])
+	}
+	if len(current["http://example.com/a"].History) != 1 {
+		t.Errorf("Expected the record of a URL not fetched to be carried forward, got %+v", current["http://example.com/a"])
+	}
+}
+
+func TestLoadIndex_Missing(t *testing.T) {
+	index, err := LoadIndex(t.TempDir())
+	if err != nil {
+		t.Errorf("Expected no error, got %v", err)
+	}
+	if len(index) != 0 {
+		t.Errorf("Expected empty index, got %v", index)
+	}
+}
+
+func TestLoadIndex_Corrupt(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, indexFile), []byte("{\"URL\":\"http://example.com\"}\nbroken\n"), 0644)
+
+	_, err := LoadIndex(dir)
+	if err == nil || !strings.Contains(err.Error(), indexFile+":2") {
+		t.Errorf("Expected error on line 2, got %v", err)
+	}
+}
+
+func TestObserve(t *testing.T) {
+	day := time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+
+	var history []Version
+	history = Observe(history, "a", day)
+	history = Observe(history, "a", day.AddDate(0, 0, 1))
+	history = Observe(history, "b", day.AddDate(0, 0, 2))
+	history = Observe(history, "", day.AddDate(0, 0, 3))
+
+	if len(history) != 2 {
+		t.Fatalf("Expected 2 versions, got %+v", history)
+	}
+	if history[0].Crawls != 2 || !history[0].LastSeen.Equal(day.AddDate(0, 0, 1)) {
+		t.Errorf("Expected unchanged content to extend the first version, got %+v", history[0])
+	}
+	if history[1].Hash != "b" || history[1].Crawls != 1 {
+		t.Errorf("Expected changed content to start a new version, got %+v", history[1])
+	}
+
+	for i := 0; i < HistoryLimit; i++ {
+		history = Observe(history, fmt.Sprint(i), day.AddDate(0, 1, i))
+	}
+	if len(history) != HistoryLimit || history[0].Hash != "0" {
+		t.Errorf("Expected only the newest %d versions, got %d starting with %q", HistoryLimit, len(history), history[0].Hash)
+	}
+}
+
+func TestChangeInterval(t *testing.T) {
+	day := time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+	crawl := func(hashes ...string) []Version {
+		var history []Version
+		for i, hash := range hashes {
+			history = Observe(history, hash, day.AddDate(0, 0, i))
+		}
+		return history
+	}
+
+	if _, ok := ChangeInterval(crawl("a", "b"), 3); ok {
+		t.Errorf("Expected no estimate from 2 crawls")
+	}
+	if _, ok := ChangeInterval(nil, 3); ok {
+		t.Errorf("Expected no estimate without history")
+	}
+
+	interval, ok := ChangeInterval(crawl("a", "a", "a"), 3)
+	if !ok || interval != math.MaxInt64 {
+		t.Errorf("Expected longest interval for unchanged content, got %v %v", interval, ok)
+	}
+
+	// Content changing on every daily crawl may change more than once a day.
+	interval, ok = ChangeInterval(crawl("a", "b", "c", "d"), 3)
+	if !ok || interval >= 24*time.Hour || interval < 12*time.Hour {
+		t.Errorf("Expected an interval under a day, got %v %v", interval, ok)
+	}
+
+	interval, ok = ChangeInterval(crawl("a", "a", "b", "b", "c"), 3)
+	if !ok || interval < 24*time.Hour || interval > 48*time.Hour {
+		t.Errorf("Expected an interval between 1 and 2 days, got %v %v", interval, ok)
+	}
+}
+
+func TestReplay_Redirect(t *testing.T) {
+	input := strings.Join([]string{
+		`{"op":"start","url":"http://example.com"}`,
+		`{"op":"enqueue","url":"http://example.com/old","depth":1}`,
+		`{"op":"page","page":{"Location":"http://example.com/new","Redirects":[{"URL":"http://example.com/old","Status":301}]}}`,
+	}, "\n") + "\n"
+
+	cp, _, err := Replay(strings.NewReader(input))
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if len(cp.Frontier) != 0 {
+		t.Errorf("Expected the redirected URL to be finished, got frontier %v", cp.Frontier)
+	}
+}
+
+func TestResume_FailuresAndRedirects(t *testing.T) {
+	dir := t.TempDir()
+	j, err := Create(dir, "http://example.com", time.Hour)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	loop := sitemap.RedirectChain{
+		Hops:  []sitemap.Hop{{URL: "http://example.com/loop-a", Status: 302}, {URL: "http://example.com/loop-b", Status: 302}},
+		Final: "http://example.com/loop-a",
+		Err:   sitemap.ErrRedirectLoop,
+	}
+	duplicate := sitemap.RedirectChain{Hops: []sitemap.Hop{{URL: "http://example.com/moved", Status: 301}}, Final: "http://example.com/new"}
+
+	j.Page(sitemap.Page{Location: "http://example.com/new", Redirects: []sitemap.Hop{{URL: "http://example.com/old", Status: 301}}})
+	j.Fail("http://example.com/moved", "", &duplicate)
+	j.Fail("http://example.com/loop-a", (&sitemap.RedirectError{Chain: loop}).Error(), &loop)
+	j.Fail("http://example.com/down", "connection refused", nil)
+	if err := j.Close(); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	j, cp, err := Resume(dir, "http://example.com", time.Hour)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	defer j.Close()
+
+	if len(cp.Failures) != 2 || cp.Failures["http://example.com/down"] != "connection refused" {
+		t.Errorf("Expected the errors of failed URLs, got %v", cp.Failures)
+	}
+	if len(cp.Redirects) != 3 {
+		t.Fatalf("Expected 3 redirect chains, got %v", cp.Redirects)
+	}
+	if cp.Redirects[0].String() != "http://example.com/old -301-> http://example.com/new" || cp.Redirects[0].Err != nil {
+		t.Errorf("Expected the chain of the redirected page, got %v", cp.Redirects[0])
+	}
+	if cp.Redirects[1].String() != duplicate.String() || cp.Redirects[1].Err != nil {
+		t.Errorf("Expected the chain to an already crawled page, got %v", cp.Redirects[1])
+	}
+	if cp.Redirects[2].String() != loop.String() || !errors.Is(cp.Redirects[2].Err, sitemap.ErrRedirectLoop) {
+		t.Errorf("Expected the redirect loop, got %v (%v)", cp.Redirects[2], cp.Redirects[2].Err)
+	}
+}
@@ -0,0 +1,95 @@
+package metrics
+
+import (
+	"bytes"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestCounter_WriteText(t *testing.T) {
+	c := NewCounter("pages_total", "Pages fetched.", "class")
+	c.Inc("2xx")
+	c.Inc("2xx")
+	c.Add(3, "4xx")
+
+	var buf bytes.Buffer
+	if err := c.WriteText(&buf); err != nil {
+		t.Errorf("Expected no error, got %v", err)
+	}
+
+	expected := "# HELP pages_total Pages fetched.\n# TYPE pages_total counter\npages_total{class=\"2xx\"} 2\npages_total{class=\"4xx\"} 3\n"
+	if buf.String() != expected {
+		t.Errorf("Expected %q, got %q", expected, buf.String())
+	}
+}
+
+func TestCounter_NoSamples(t *testing.T) {
+	c := NewCounter("bytes_total", "Bytes.")
+
+	var buf bytes.Buffer
+	c.WriteText(&buf)
+
+	if !strings.HasSuffix(buf.String(), "bytes_total 0\n") {
+		t.Errorf("Expected zero sample, got %q", buf.String())
+	}
+}
+
+func TestGauge_Set(t *testing.T) {
+	g := NewGauge("queue_depth", "Queue depth.")
+	g.Set(5)
+	g.Add(-2)
+
+	var buf bytes.Buffer
+	g.WriteText(&buf)
+
+	if !strings.Contains(buf.String(), "queue_depth 3\n") {
+		t.Errorf("Expected queue_depth 3, got %q", buf.String())
+	}
+}
+
+func TestHistogram_Observe(t *testing.T) {
+	h := NewHistogram("latency_seconds", "Latency.", []float64{1, 0.5})
+	h.Observe(0.2)
+	h.Observe(0.7)
+	h.Observe(3)
+
+	var buf bytes.Buffer
+	h.WriteText(&buf)
+
+	for _, line := range []string{
+		`latency_seconds_bucket{le="0.5"} 1`,
+		`latency_seconds_bucket{le="1"} 2`,
+		`latency_seconds_bucket{le="+Inf"} 3`,
+		`latency_seconds_sum 3.9`,
+		`latency_seconds_count 3`,
+	} {
+		if !strings.Contains(buf.String(), line+"\n") {
+			t.Errorf("Expected line %q in %q", line, buf.String())
+		}
+	}
+}
+
+func TestEscapeLabelValue(t *testing.T) {
+	got := escapeLabelValue("a\"b\\c\nd")
+	if got != `a\"b\\c\nd` {
+		t.Errorf("Expected escaped value, got %s", got)
+	}
+}
+
+func TestRegistry_ServeHTTP(t *testing.T) {
+	r := NewRegistry()
+	c := NewCounter("urls_total", "URLs.")
+	c.Inc()
+	r.Register(c)
+
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+
+	if !strings.HasPrefix(rec.Header().Get("Content-Type"), "text/plain") {
+		t.Errorf("Expected text/plain content type, got %s", rec.Header().Get("Content-Type"))
+	}
+	if !strings.Contains(rec.Body.String(), "urls_total 1\n") {
+		t.Errorf("Expected urls_total 1, got %q", rec.Body.String())
+	}
+}
@@ -0,0 +1,154 @@
+package sitemap
+
+import (
+	"encoding/json"
+	"fmt"
+	"html"
+	"io"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+var anchorEndPattern = regexp.MustCompile(`(?i)</a\s*>`)
+
+// Anchor is a link found on a page and the text it is displayed with.
+type Anchor struct {
+	URL  string
+	Text string `json:",omitempty"`
+}
+
+// anchorText returns the visible text of an <a> element from its content up to
+// the closing tag, or nothing when the element isn't closed.
+func anchorText(content string) string {
+	end := anchorEndPattern.FindStringIndex(content)
+	if end == nil {
+		return ""
+	}
+
+	text := html.UnescapeString(tagPattern.ReplaceAllString(content[:end[0]], " "))
+
+	return strings.TrimSpace(whitespacePattern.ReplaceAllString(text, " "))
+}
+
+func anchorURLs(anchors []Anchor) []string {
+	var links []string
+	for _, anchor := range anchors {
+		links = append(links, anchor.URL)
+	}
+	return links
+}
+
+// Referrer is a page linking to a broken URL and the text of the link.
+type Referrer struct {
+	Page string
+	Text string `json:",omitempty"`
+}
+
+// BrokenLink is a crawled URL that answered with an error status or couldn't
+// be fetched at all, with the pages linking to it.
+type BrokenLink struct {
+	URL       string
+	Status    int        `json:",omitempty"`
+	Error     string     `json:",omitempty"`
+	Referrers []Referrer `json:",omitempty"`
+}
+
+// Problem describes why the link is broken, e.g. "404 Not Found".
+func (link BrokenLink) Problem() string {
+	if link.Status != 0 {
+		return fmt.Sprintf("%d %s", link.Status, http.StatusText(link.Status))
+	}
+	return link.Error
+}
+
+// IsBroken reports whether a fetched page answered with a client or server
+// error status.
+func IsBroken(page Page) bool {
+	return page.StatusCode >= http.StatusBadRequest
+}
+
+// FindBrokenLinks lists the pages answering with an error status and the URLs
+// in failures, which maps URLs that couldn't be fetched to the error, sorted
+// by URL. Every anchor of pages pointing at one of them is a referrer, and so
+// are anchors pointing at a URL that redirects to one of them, either on the
+// way to a page or in chains.
+func FindBrokenLinks(pages []Page, failures map[string]string, chains []RedirectChain) []BrokenLink {
+	var links []*BrokenLink
+	broken := make(map[string]*BrokenLink)
+	for url, err := range failures {
+		link := &BrokenLink{URL: url, Error: err}
+		links = append(links, link)
+		broken[url] = link
+	}
+	for _, page := range pages {
+		if !IsBroken(page) {
+			continue
+		}
+
+		link := &BrokenLink{URL: page.Location, Status: page.StatusCode}
+		links = append(links, link)
+		broken[page.Location] = link
+		for _, hop := range page.Redirects {
+			broken[hop.URL] = link
+		}
+	}
+	for _, chain := range chains {
+		if link, ok := broken[chain.Final]; ok && chain.Err == nil {
+			for _, hop := range chain.Hops {
+				broken[hop.URL] = link
+			}
+		}
+	}
+
+	seen := make(map[*BrokenLink]map[Referrer]bool)
+	for _, page := range pages {
+		for _, anchor := range page.Anchors {
+			link, ok := broken[anchor.URL]
+			if !ok {
+				continue
+			}
+
+			referrer := Referrer{Page: page.Location, Text: anchor.Text}
+			if seen[link] == nil {
+				seen[link] = make(map[Referrer]bool)
+			}
+			if !seen[link][referrer] {
+				seen[link][referrer] = true
+				link.Referrers = append(link.Referrers, referrer)
+			}
+		}
+	}
+
+	sort.Slice(links, func(i, j int) bool { return links[i].URL < links[j].URL })
+
+	report := make([]BrokenLink, len(links))
+	for i, link := range links {
+		report[i] = *link
+	}
+	return report
+}
+
+// WriteBrokenLinks writes a plain text report listing each broken URL, the
+// problem and its referrers.
+func WriteBrokenLinks(w io.Writer, links []BrokenLink) error {
+	for _, link := range links {
+		if _, err := fmt.Fprintf(w, "%s\t%s\n", link.URL, link.Problem()); err != nil {
+			return err
+		}
+		for _, referrer := range link.Referrers {
+			if _, err := fmt.Fprintf(w, "\tlinked from %s %q\n", referrer.Page, referrer.Text); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// WriteBrokenLinksJSON writes the report as an indented JSON array.
+func WriteBrokenLinksJSON(w io.Writer, links []BrokenLink) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(links)
+}
@@ -0,0 +1,38 @@
+package sitemap
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"html"
+	"regexp"
+	"strings"
+)
+
+var ignoredContentPattern = regexp.MustCompile(`(?is)<script\b.*?</script>|<style\b.*?</style>|<noscript\b.*?</noscript>|<template\b.*?</template>|<!--.*?-->`)
+var mainPattern = regexp.MustCompile(`(?is)<main\b[^>]*>(.*)</main>`)
+var articlePattern = regexp.MustCompile(`(?is)<article\b[^>]*>(.*)</article>`)
+var bodyPattern = regexp.MustCompile(`(?is)<body\b[^>]*>(.*)</body>`)
+var tagPattern = regexp.MustCompile(`<[^>]*>`)
+var whitespacePattern = regexp.MustCompile(`\s+`)
+
+// NormaliseContent reduces a page to the visible text of its main content, so
+// that markup churn, scripts and whitespace don't count as a change.
+func NormaliseContent(page string) string {
+	page = ignoredContentPattern.ReplaceAllString(page, " ")
+
+	for _, pattern := range []*regexp.Regexp{mainPattern, articlePattern, bodyPattern} {
+		if match := pattern.FindStringSubmatch(page); match != nil {
+			page = match[1]
+			break
+		}
+	}
+
+	text := html.UnescapeString(tagPattern.ReplaceAllString(page, " "))
+
+	return strings.TrimSpace(whitespacePattern.ReplaceAllString(text, " "))
+}
+
+func ContentHash(page string) string {
+	sum := sha256.Sum256([]byte(NormaliseContent(page)))
+	return hex.EncodeToString(sum[:])
+}
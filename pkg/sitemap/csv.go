@@ -0,0 +1,123 @@
+package sitemap
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+var CSVColumns = []string{
+	"url",
+	"final_url",
+	"status",
+	"content_type",
+	"depth",
+	"title",
+	"description_length",
+	"canonical",
+	"inbound_links",
+	"response_time_ms",
+	"included",
+	"reason",
+}
+
+var csvValues = map[string]func(Page) string{
+	"url":                func(p Page) string { return p.RequestedURL() },
+	"final_url":          func(p Page) string { return p.Location },
+	"status":             func(p Page) string { return strconv.Itoa(p.StatusCode) },
+	"content_type":       func(p Page) string { return p.ContentType },
+	"depth":              func(p Page) string { return strconv.Itoa(p.Depth) },
+	"title":              func(p Page) string { return p.Title },
+	"description_length": func(p Page) string { return strconv.Itoa(len([]rune(p.Description))) },
+	"canonical":          func(p Page) string { return p.Canonical },
+	"inbound_links":      func(p Page) string { return strconv.Itoa(p.InboundLinks) },
+	"response_time_ms":   func(p Page) string { return strconv.FormatInt(p.FetchDuration.Milliseconds(), 10) },
+	"included":           func(p Page) string { return strconv.FormatBool(p.Inclusion.Included) },
+	"reason":             func(p Page) string { return p.Inclusion.Reason },
+}
+
+func ParseCSVColumns(list string) ([]string, error) {
+	var columns []string
+	for _, column := range strings.Split(list, ",") {
+		column = strings.ToLower(strings.TrimSpace(column))
+		if column == "" {
+			continue
+		}
+		if _, ok := csvValues[column]; !ok {
+			return nil, fmt.Errorf("unknown csv column %q", column)
+		}
+		columns = append(columns, column)
+	}
+
+	if len(columns) == 0 {
+		return nil, fmt.Errorf("no csv columns selected")
+	}
+
+	return columns, nil
+}
+
+type CSVWriter struct {
+	w       *csv.Writer
+	columns []string
+	started bool
+}
+
+// NewCSVWriter writes a crawl report with one row per page. Columns must be
+// names from CSVColumns.
+func NewCSVWriter(w io.Writer, columns []string) *CSVWriter {
+	return &CSVWriter{w: csv.NewWriter(w), columns: columns}
+}
+
+func (cw *CSVWriter) start() error {
+	if cw.started {
+		return nil
+	}
+	cw.started = true
+
+	return cw.w.Write(cw.columns)
+}
+
+func (cw *CSVWriter) Write(page Page) error {
+	if err := cw.start(); err != nil {
+		return err
+	}
+
+	row := make([]string, len(cw.columns))
+	for i, column := range cw.columns {
+		if value, ok := csvValues[column]; ok {
+			row[i] = value(page)
+		}
+	}
+
+	return cw.w.Write(row)
+}
+
+func (cw *CSVWriter) Close() error {
+	if err := cw.start(); err != nil {
+		return err
+	}
+
+	cw.w.Flush()
+	return cw.w.Error()
+}
+
+// CountInboundLinks sets InboundLinks on every page to the number of other
+// pages in the set that link to it.
+func CountInboundLinks(pages []Page) {
+	inbound := make(map[string]int)
+	for _, page := range pages {
+		linked := make(map[string]bool)
+		for _, link := range page.Links {
+			if link != page.Location && !linked[link] {
+				linked[link] = true
+				inbound[link]++
+			}
+		}
+	}
+
+	for i := range pages {
+		pages[i].InboundLinks = inbound[pages[i].Location]
+	}
+}
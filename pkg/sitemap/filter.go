@@ -0,0 +1,82 @@
+package sitemap
+
+import (
+	"net/url"
+	"path"
+	"strings"
+)
+
+// SkippedExtensions are file types that are neither crawled nor listed by
+// default: images, media, archives, executables, fonts and data files.
+var SkippedExtensions = []string{
+	".png", ".jpg", ".jpeg", ".gif", ".bmp", ".tif", ".tiff", ".svg", ".webp", ".ico", ".psd", ".ai", ".dwg",
+	".mp3", ".wav", ".mid", ".midi", ".ogg", ".aac", ".ac3", ".cda", ".flac", ".m4a",
+	".mp4", ".avi", ".mov", ".mpeg", ".mpg", ".ogm", ".webm", ".mkv", ".wmv", ".swf",
+	".zip", ".tar", ".gz", ".tgz", ".bz2", ".xz", ".7z", ".rar",
+	".exe", ".msi", ".dll", ".bat", ".lnk", ".dmg", ".apk",
+	".ttf", ".otf", ".woff", ".woff2",
+	".css", ".js", ".json", ".xml", ".db",
+}
+
+// DocumentExtensions are documents search engines index. They are skipped
+// unless the filter opts in to documents.
+var DocumentExtensions = []string{
+	".pdf", ".txt", ".rtf", ".doc", ".docx", ".xls", ".xlsx", ".ppt", ".pptx", ".odt", ".ods", ".odp", ".epub",
+}
+
+// LinkSchemes are the schemes followed by default.
+var LinkSchemes = []string{"http", "https"}
+
+// LinkFilter decides which links are worth crawling by their scheme and the
+// extension of their path.
+type LinkFilter struct {
+	skipped map[string]bool
+	schemes map[string]bool
+}
+
+// NewLinkFilter skips SkippedExtensions, and DocumentExtensions unless
+// documents is set, and only follows LinkSchemes.
+func NewLinkFilter(documents bool) *LinkFilter {
+	f := &LinkFilter{skipped: make(map[string]bool), schemes: make(map[string]bool)}
+	f.Skip(SkippedExtensions...)
+	if !documents {
+		f.Skip(DocumentExtensions...)
+	}
+	for _, scheme := range LinkSchemes {
+		f.schemes[scheme] = true
+	}
+	return f
+}
+
+// DefaultLinkFilter is used by parsers without a Filter.
+var DefaultLinkFilter = NewLinkFilter(false)
+
+// Skip adds extensions, with or without their leading dot, to the filter.
+func (f *LinkFilter) Skip(extensions ...string) {
+	for _, extension := range extensions {
+		f.skipped["."+strings.TrimPrefix(strings.ToLower(extension), ".")] = true
+	}
+}
+
+// Allow removes extensions from the filter, e.g. to crawl a single document
+// type.
+func (f *LinkFilter) Allow(extensions ...string) {
+	for _, extension := range extensions {
+		delete(f.skipped, "."+strings.TrimPrefix(strings.ToLower(extension), "."))
+	}
+}
+
+// AllowsScheme reports whether a link with the given scheme is followed.
+// Relative links have no scheme and are always allowed.
+func (f *LinkFilter) AllowsScheme(scheme string) bool {
+	return scheme == "" || f.schemes[strings.ToLower(scheme)]
+}
+
+// Allows reports whether u has a followed scheme and its path does not end
+// in a skipped extension. The host, query and fragment are not considered.
+func (f *LinkFilter) Allows(u *url.URL) bool {
+	if !f.AllowsScheme(u.Scheme) {
+		return false
+	}
+	return !f.skipped[strings.ToLower(path.Ext(u.Path))]
+}
@@ -1,19 +1,28 @@
 package sitemap
 
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
 type Frequency int64
 
+// None is an unset changefreq, which is left out of the sitemap.
 const (
-	Always  Frequency = 0
-	Hourly  Frequency = 1
-	Daily   Frequency = 2
-	Weekly  Frequency = 3
-	Monthly Frequency = 4
-	Yearly  Frequency = 5
-	Never   Frequency = 6
+	None    Frequency = 0
+	Always  Frequency = 1
+	Hourly  Frequency = 2
+	Daily   Frequency = 3
+	Weekly  Frequency = 4
+	Monthly Frequency = 5
+	Yearly  Frequency = 6
+	Never   Frequency = 7
 )
 
 func (freq Frequency) String() string {
 	return []string{
+		"None",
 		"Always",
 		"Hourly",
 		"Daily",
@@ -23,3 +32,58 @@ func (freq Frequency) String() string {
 		"Never",
 	}[freq]
 }
+
+// ParseFrequency reads a changefreq value such as "weekly".
+func ParseFrequency(name string) (Frequency, error) {
+	for freq := Always; freq <= Never; freq++ {
+		if strings.EqualFold(name, freq.String()) {
+			return freq, nil
+		}
+	}
+	return None, fmt.Errorf("unknown changefreq %q", name)
+}
+
+// MarshalText writes the changefreq value defined by the sitemap protocol, or
+// nothing for None.
+func (freq Frequency) MarshalText() ([]byte, error) {
+	if freq == None {
+		return []byte{}, nil
+	}
+	return []byte(strings.ToLower(freq.String())), nil
+}
+
+func (freq *Frequency) UnmarshalText(text []byte) error {
+	if len(text) == 0 {
+		*freq = None
+		return nil
+	}
+	parsed, err := ParseFrequency(string(text))
+	if err != nil {
+		return err
+	}
+	*freq = parsed
+	return nil
+}
+
+// frequencyBounds are the longest change intervals mapped onto each changefreq,
+// about halfway on a log scale between one period and the next.
+var frequencyBounds = []struct {
+	interval time.Duration
+	freq     Frequency
+}{
+	{5 * time.Hour, Hourly},
+	{3 * 24 * time.Hour, Daily},
+	{14 * 24 * time.Hour, Weekly},
+	{105 * 24 * time.Hour, Monthly},
+}
+
+// FrequencyFor maps the mean interval between content changes onto the closest
+// changefreq. Pages changing less often than every few months are Yearly.
+func FrequencyFor(interval time.Duration) Frequency {
+	for _, bound := range frequencyBounds {
+		if interval < bound.interval {
+			return bound.freq
+		}
+	}
+	return Yearly
+}
@@ -0,0 +1,125 @@
+package sitemap
+
+import (
+	"bufio"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Graph is the internal link graph of a crawl. Nodes are crawled pages and
+// edges are links between them.
+type Graph struct {
+	Nodes    []GraphNode
+	Edges    []GraphEdge
+	Weighted bool
+}
+
+type GraphNode struct {
+	URL    string
+	Depth  int
+	Status int
+}
+
+type GraphEdge struct {
+	From   string
+	To     string
+	Weight int
+}
+
+// BuildGraph collects every link from one crawled page to another. With
+// dedupe set repeated links between the same pair of pages become a single
+// edge weighted by the number of links.
+func BuildGraph(pages []Page, dedupe bool) Graph {
+	graph := Graph{Weighted: dedupe}
+
+	crawled := make(map[string]bool)
+	for _, page := range pages {
+		crawled[page.Location] = true
+		graph.Nodes = append(graph.Nodes, GraphNode{URL: page.Location, Depth: page.Depth, Status: page.StatusCode})
+	}
+
+	for _, page := range pages {
+		edges := make(map[string]int)
+		for _, link := range page.Links {
+			if !crawled[link] {
+				continue
+			}
+
+			if i, ok := edges[link]; ok && dedupe {
+				graph.Edges[i].Weight++
+				continue
+			}
+
+			edges[link] = len(graph.Edges)
+			graph.Edges = append(graph.Edges, GraphEdge{From: page.Location, To: link, Weight: 1})
+		}
+	}
+
+	return graph
+}
+
+// WriteDOT writes the graph in the Graphviz DOT language.
+func (g Graph) WriteDOT(w io.Writer) error {
+	out := bufio.NewWriter(w)
+
+	fmt.Fprintln(out, "digraph sitemap {")
+	for _, node := range g.Nodes {
+		fmt.Fprintf(out, "  %s [depth=%d, status=%d];\n", dotID(node.URL), node.Depth, node.Status)
+	}
+	for _, edge := range g.Edges {
+		if g.Weighted {
+			fmt.Fprintf(out, "  %s -> %s [weight=%d];\n", dotID(edge.From), dotID(edge.To), edge.Weight)
+		} else {
+			fmt.Fprintf(out, "  %s -> %s;\n", dotID(edge.From), dotID(edge.To))
+		}
+	}
+	fmt.Fprintln(out, "}")
+
+	return out.Flush()
+}
+
+func dotID(id string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(id) + `"`
+}
+
+// WriteGraphML writes the graph as a GraphML document.
+func (g Graph) WriteGraphML(w io.Writer) error {
+	out := bufio.NewWriter(w)
+
+	fmt.Fprintln(out, xml.Header+`<graphml xmlns="http://graphml.graphdrawing.org/xmlns">`)
+	fmt.Fprintln(out, `  <key id="depth" for="node" attr.name="depth" attr.type="int"/>`)
+	fmt.Fprintln(out, `  <key id="status" for="node" attr.name="status" attr.type="int"/>`)
+	if g.Weighted {
+		fmt.Fprintln(out, `  <key id="weight" for="edge" attr.name="weight" attr.type="int"/>`)
+	}
+	fmt.Fprintln(out, `  <graph id="sitemap" edgedefault="directed">`)
+
+	for _, node := range g.Nodes {
+		fmt.Fprintf(out, "    <node id=\"%s\">\n", xmlAttr(node.URL))
+		fmt.Fprintf(out, "      <data key=\"depth\">%d</data>\n", node.Depth)
+		fmt.Fprintf(out, "      <data key=\"status\">%d</data>\n", node.Status)
+		fmt.Fprintln(out, "    </node>")
+	}
+	for _, edge := range g.Edges {
+		if g.Weighted {
+			fmt.Fprintf(out, "    <edge source=\"%s\" target=\"%s\">\n", xmlAttr(edge.From), xmlAttr(edge.To))
+			fmt.Fprintf(out, "      <data key=\"weight\">%d</data>\n", edge.Weight)
+			fmt.Fprintln(out, "    </edge>")
+		} else {
+			fmt.Fprintf(out, "    <edge source=\"%s\" target=\"%s\"/>\n", xmlAttr(edge.From), xmlAttr(edge.To))
+		}
+	}
+
+	fmt.Fprintln(out, "  </graph>")
+	fmt.Fprintln(out, "</graphml>")
+
+	return out.Flush()
+}
+
+func xmlAttr(value string) string {
+	var escaped strings.Builder
+	xml.EscapeText(&escaped, []byte(value))
+	return escaped.String()
+}
@@ -0,0 +1,96 @@
+package sitemap
+
+import (
+	"fmt"
+	"net"
+	"strings"
+)
+
+type HostPolicy string
+
+const (
+	// HostExact only crawls the start URL's host.
+	HostExact HostPolicy = "exact"
+	// HostWWW also crawls the www alias of the start host, or the bare host
+	// when the start host begins with www.
+	HostWWW HostPolicy = "www"
+	// HostDomain crawls every subdomain of the start host's registrable
+	// domain.
+	HostDomain HostPolicy = "domain"
+	// HostAllowlist crawls the start host and the listed hosts.
+	HostAllowlist HostPolicy = "allowlist"
+)
+
+func ParseHostPolicy(policy string) (HostPolicy, error) {
+	switch HostPolicy(policy) {
+	case HostExact, HostWWW, HostDomain, HostAllowlist:
+		return HostPolicy(policy), nil
+	}
+	return "", fmt.Errorf("unknown host policy %q", policy)
+}
+
+// HostScope decides which hosts belong to a crawl that started on Start.
+type HostScope struct {
+	Policy HostPolicy
+	Start  string
+
+	// Domain overrides the registrable domain derived from Start for
+	// HostDomain.
+	Domain string
+	// Hosts are the extra hosts crawled under HostAllowlist.
+	Hosts []string
+}
+
+func (s *HostScope) Allows(host string) bool {
+	host = strings.ToLower(host)
+	start := strings.ToLower(s.Start)
+	if host == start {
+		return true
+	}
+
+	switch s.Policy {
+	case HostWWW:
+		return strings.TrimPrefix(host, "www.") == strings.TrimPrefix(start, "www.")
+	case HostDomain:
+		domain := strings.ToLower(s.Domain)
+		if domain == "" {
+			domain = RegistrableDomain(start)
+		}
+		return host == domain || strings.HasSuffix(host, "."+domain)
+	case HostAllowlist:
+		for _, allowed := range s.Hosts {
+			if host == strings.ToLower(allowed) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// RegistrableDomain approximates the domain a host was registered under: its
+// last two labels, or three under two-level country suffixes such as co.uk
+// or com.au. IP addresses are returned unchanged.
+func RegistrableDomain(host string) string {
+	if net.ParseIP(host) != nil {
+		return host
+	}
+
+	labels := strings.Split(strings.TrimSuffix(host, "."), ".")
+	if len(labels) <= 2 {
+		return host
+	}
+
+	n := 2
+	tld, sld := labels[len(labels)-1], labels[len(labels)-2]
+	if len(tld) == 2 && secondLevelSuffixes[sld] {
+		n = 3
+	}
+
+	return strings.Join(labels[len(labels)-n:], ".")
+}
+
+var secondLevelSuffixes = map[string]bool{
+	"ac": true, "co": true, "com": true, "edu": true, "gov": true,
+	"net": true, "org": true, "ne": true, "or": true, "go": true,
+}
@@ -0,0 +1,180 @@
+package sitemap
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+var XHTMLExtension = Extension{Prefix: "xhtml", Namespace: "http://www.w3.org/1999/xhtml"}
+
+const (
+	IssueNonReciprocal   = "non-reciprocal"
+	IssueMissingXDefault = "missing-x-default"
+	IssueConflict        = "conflict"
+)
+
+type Alternate struct {
+	Rel      string `xml:"rel,attr"`
+	Hreflang string `xml:"hreflang,attr"`
+	Href     string `xml:"href,attr"`
+}
+
+type HreflangIssue struct {
+	Kind   string
+	URL    string
+	Detail string
+}
+
+func (issue HreflangIssue) String() string {
+	return fmt.Sprintf("%s: %s: %s", issue.Kind, issue.URL, issue.Detail)
+}
+
+var linkTagPattern = regexp.MustCompile(`(?is)<link\b[^>]*>`)
+var linkHeaderPattern = regexp.MustCompile(`<([^>]*)>((?:\s*;\s*[^;,]+)*)`)
+var linkParamPattern = regexp.MustCompile(`;\s*([a-zA-Z*-]+)\s*=\s*(?:"([^"]*)"|([^;,\s]+))`)
+
+// extractAlternates reads hreflang annotations from <link rel="alternate">
+// tags and Link response headers.
+func extractAlternates(resp *http.Response, page string, base *url.URL) []Alternate {
+	var alternates []Alternate
+	seen := make(map[Alternate]bool)
+
+	add := func(rel, hreflang, href string) {
+		if hreflang == "" || !hasToken(rel, "alternate") {
+			return
+		}
+
+		loc, ok := resolveURL(base, href)
+		if !ok {
+			return
+		}
+
+		alternate := Alternate{Rel: "alternate", Hreflang: strings.ToLower(strings.TrimSpace(hreflang)), Href: loc.String()}
+		if !seen[alternate] {
+			seen[alternate] = true
+			alternates = append(alternates, alternate)
+		}
+	}
+
+	for _, link := range findTags(linkTagPattern, page) {
+		add(link["rel"], link["hreflang"], link["href"])
+	}
+
+	for _, header := range resp.Header.Values("Link") {
+		for _, match := range linkHeaderPattern.FindAllStringSubmatch(header, -1) {
+			params := make(map[string]string)
+			for _, param := range linkParamPattern.FindAllStringSubmatch(match[2], -1) {
+				params[strings.ToLower(param[1])] = param[2] + param[3]
+			}
+			add(params["rel"], params["hreflang"], match[1])
+		}
+	}
+
+	return alternates
+}
+
+func hasToken(list, token string) bool {
+	for _, field := range strings.Fields(strings.ToLower(list)) {
+		if field == token {
+			return true
+		}
+	}
+	return false
+}
+
+// ApplyHreflang groups pages into clusters of alternates, treating every
+// hreflang annotation as linking two URLs, and gives each member page the
+// annotations of its whole cluster. It reports annotations that aren't
+// returned by their target, clusters without an x-default, and languages
+// claimed by more than one URL.
+func ApplyHreflang(pages []Page) []HreflangIssue {
+	parent := make(map[string]string)
+
+	var find func(string) string
+	find = func(u string) string {
+		if parent[u] == "" || parent[u] == u {
+			parent[u] = u
+			return u
+		}
+		root := find(parent[u])
+		parent[u] = root
+		return root
+	}
+	union := func(a, b string) {
+		ra, rb := find(a), find(b)
+		if ra != rb {
+			parent[ra] = rb
+		}
+	}
+
+	declared := make(map[string][]Alternate)
+	for _, page := range pages {
+		declared[page.Location] = page.Alternates
+		for _, alternate := range page.Alternates {
+			union(page.Location, alternate.Href)
+		}
+	}
+
+	var issues []HreflangIssue
+
+	clusters := make(map[string][]Alternate)
+	claimed := make(map[string]map[string]string)
+	var roots []string
+
+	for _, page := range pages {
+		if len(page.Alternates) == 0 {
+			continue
+		}
+
+		root := find(page.Location)
+		if claimed[root] == nil {
+			claimed[root] = make(map[string]string)
+			roots = append(roots, root)
+		}
+
+		for _, alternate := range page.Alternates {
+			if alternate.Href != page.Location {
+				if back, crawled := declared[alternate.Href]; crawled && !declaresHref(back, page.Location) {
+					issues = append(issues, HreflangIssue{Kind: IssueNonReciprocal, URL: page.Location, Detail: fmt.Sprintf("%s (%s) does not link back", alternate.Href, alternate.Hreflang)})
+				}
+			}
+
+			if href, ok := claimed[root][alternate.Hreflang]; ok {
+				if href != alternate.Href {
+					issues = append(issues, HreflangIssue{Kind: IssueConflict, URL: page.Location, Detail: fmt.Sprintf("%s is declared as both %s and %s", alternate.Hreflang, href, alternate.Href)})
+				}
+				continue
+			}
+			claimed[root][alternate.Hreflang] = alternate.Href
+			clusters[root] = append(clusters[root], alternate)
+		}
+	}
+
+	for _, root := range roots {
+		sort.Slice(clusters[root], func(i, j int) bool { return clusters[root][i].Hreflang < clusters[root][j].Hreflang })
+		if _, ok := claimed[root]["x-default"]; !ok {
+			issues = append(issues, HreflangIssue{Kind: IssueMissingXDefault, URL: clusters[root][0].Href, Detail: fmt.Sprintf("cluster of %d alternates has no x-default", len(clusters[root]))})
+		}
+	}
+
+	for i := range pages {
+		if _, ok := parent[pages[i].Location]; ok {
+			pages[i].Alternates = clusters[find(pages[i].Location)]
+		}
+	}
+
+	return issues
+}
+
+func declaresHref(alternates []Alternate, href string) bool {
+	for _, alternate := range alternates {
+		if alternate.Href == href {
+			return true
+		}
+	}
+	return false
+}
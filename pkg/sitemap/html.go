@@ -0,0 +1,122 @@
+package sitemap
+
+import (
+	"encoding/json"
+	"html"
+	"regexp"
+	"strings"
+)
+
+var metaTagPattern = regexp.MustCompile(`(?is)<meta\b[^>]*>`)
+var timeTagPattern = regexp.MustCompile(`(?is)<time\b[^>]*>`)
+var attributePattern = regexp.MustCompile(`(?s)([a-zA-Z_:][-a-zA-Z0-9_:.]*)\s*=\s*(?:"([^"]*)"|'([^']*)'|([^\s"'>]+))`)
+var jsonLDPattern = regexp.MustCompile(`(?is)<script\b[^>]*type\s*=\s*["']?application/ld\+json["']?[^>]*>(.*?)</script>`)
+
+// findTags returns the attributes of every tag matched by pattern, with
+// lower-cased attribute names and unescaped values.
+func findTags(pattern *regexp.Regexp, page string) []map[string]string {
+	var tags []map[string]string
+	for _, tag := range pattern.FindAllString(page, -1) {
+		tags = append(tags, parseAttributes(tag))
+	}
+	return tags
+}
+
+func parseAttributes(tag string) map[string]string {
+	attributes := make(map[string]string)
+
+	// skip the tag name so "<meta" isn't mistaken for an attribute
+	if i := strings.IndexAny(tag, " \t\r\n/"); i >= 0 {
+		tag = tag[i:]
+	}
+
+	for _, match := range attributePattern.FindAllStringSubmatch(tag, -1) {
+		name := strings.ToLower(match[1])
+		if _, ok := attributes[name]; ok {
+			continue
+		}
+		attributes[name] = html.UnescapeString(match[2] + match[3] + match[4])
+	}
+
+	return attributes
+}
+
+// jsonLDDocuments decodes every JSON-LD script on the page, skipping the
+// ones that aren't valid JSON.
+func jsonLDDocuments(page string) []interface{} {
+	var documents []interface{}
+	for _, match := range jsonLDPattern.FindAllStringSubmatch(page, -1) {
+		var document interface{}
+		if err := json.Unmarshal([]byte(strings.TrimSpace(match[1])), &document); err == nil {
+			documents = append(documents, document)
+		}
+	}
+	return documents
+}
+
+// jsonLDObjects walks decoded JSON-LD documents, including @graph and nested
+// values, and returns every object whose @type is one of types.
+func jsonLDObjects(documents []interface{}, types ...string) []map[string]interface{} {
+	var objects []map[string]interface{}
+
+	var walk func(value interface{})
+	walk = func(value interface{}) {
+		switch v := value.(type) {
+		case []interface{}:
+			for _, item := range v {
+				walk(item)
+			}
+		case map[string]interface{}:
+			if len(types) == 0 || hasJSONLDType(v, types) {
+				objects = append(objects, v)
+			}
+			for _, item := range v {
+				walk(item)
+			}
+		}
+	}
+
+	for _, document := range documents {
+		walk(document)
+	}
+
+	return objects
+}
+
+func hasJSONLDType(object map[string]interface{}, types []string) bool {
+	var values []interface{}
+	switch t := object["@type"].(type) {
+	case string:
+		values = append(values, t)
+	case []interface{}:
+		values = t
+	}
+
+	for _, value := range values {
+		for _, want := range types {
+			if s, ok := value.(string); ok && strings.EqualFold(s, want) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+func jsonLDString(object map[string]interface{}, key string) string {
+	switch v := object[key].(type) {
+	case string:
+		return strings.TrimSpace(v)
+	case map[string]interface{}:
+		for _, nested := range []string{"@id", "url", "name"} {
+			if s, ok := v[nested].(string); ok {
+				return strings.TrimSpace(s)
+			}
+		}
+	case []interface{}:
+		if len(v) > 0 {
+			return jsonLDString(map[string]interface{}{key: v[0]}, key)
+		}
+	}
+	return ""
+}
@@ -0,0 +1,145 @@
+package sitemap
+
+import (
+	"html/template"
+	"io"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+// DefaultHTMLTemplate renders the page tree as nested lists. Custom templates
+// receive the same HTMLSitemap value and may use the recursive "node"
+// template by defining their own.
+const DefaultHTMLTemplate = `<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>Sitemap – {{.Title}}</title>
+</head>
+<body>
+<h1>Sitemap – {{.Title}}</h1>
+<ul>
+{{template "node" .Root}}
+</ul>
+</body>
+</html>
+{{define "node"}}<li>{{if .Location}}<a href="{{.Location}}">{{.Text}}</a>{{else}}{{.Name}}{{end}}{{if .Children}}
+<ul>
+{{range .Children}}{{template "node" .}}
+{{end}}</ul>
+{{end}}</li>{{end}}`
+
+// HTMLSitemap is the data handed to HTML sitemap templates.
+type HTMLSitemap struct {
+	Title string
+	Pages int
+	Root  *HTMLNode
+}
+
+// HTMLNode is one path segment of the site. Location is empty for segments
+// that group pages but were not crawled themselves.
+type HTMLNode struct {
+	Name     string
+	Location string
+	Text     string
+	Children []*HTMLNode
+}
+
+func (n *HTMLNode) child(name string) *HTMLNode {
+	for _, child := range n.Children {
+		if child.Name == name {
+			return child
+		}
+	}
+
+	child := &HTMLNode{Name: name}
+	n.Children = append(n.Children, child)
+	return child
+}
+
+func (n *HTMLNode) sort() {
+	sort.Slice(n.Children, func(i, j int) bool {
+		return n.Children[i].Name < n.Children[j].Name
+	})
+	for _, child := range n.Children {
+		child.sort()
+	}
+}
+
+// ParseHTMLTemplate reads a custom HTML sitemap template from path.
+func ParseHTMLTemplate(path string) (*template.Template, error) {
+	return template.ParseFiles(path)
+}
+
+type HTMLWriter struct {
+	w     io.Writer
+	tmpl  *template.Template
+	pages []Page
+}
+
+// NewHTMLWriter renders a human-readable sitemap page grouped by URL path
+// segments. The page is written on Close; a nil template selects
+// DefaultHTMLTemplate.
+func NewHTMLWriter(w io.Writer, tmpl *template.Template) *HTMLWriter {
+	if tmpl == nil {
+		tmpl = template.Must(template.New("sitemap").Parse(DefaultHTMLTemplate))
+	}
+	return &HTMLWriter{w: w, tmpl: tmpl}
+}
+
+func (hw *HTMLWriter) Write(page Page) error {
+	hw.pages = append(hw.pages, page)
+	return nil
+}
+
+func (hw *HTMLWriter) Close() error {
+	return hw.tmpl.Execute(hw.w, BuildHTMLSitemap(hw.pages))
+}
+
+// BuildHTMLSitemap arranges pages into a tree keyed by their path segments,
+// using page titles as link text.
+func BuildHTMLSitemap(pages []Page) HTMLSitemap {
+	root := &HTMLNode{Name: "/"}
+	data := HTMLSitemap{Root: root, Pages: len(pages)}
+
+	for _, page := range pages {
+		pageUrl, err := url.Parse(page.Location)
+		if err != nil {
+			continue
+		}
+		if data.Title == "" {
+			data.Title = pageUrl.Host
+			root.Name = pageUrl.Host
+		}
+
+		node := root
+		for _, segment := range pathSegments(pageUrl) {
+			node = node.child(segment)
+		}
+
+		node.Location = page.Location
+		node.Text = page.Title
+		if node.Text == "" {
+			node.Text = page.Location
+		}
+	}
+
+	root.sort()
+	return data
+}
+
+func pathSegments(pageUrl *url.URL) []string {
+	var segments []string
+	for _, segment := range strings.Split(pageUrl.Path, "/") {
+		if segment != "" {
+			segments = append(segments, segment)
+		}
+	}
+
+	if pageUrl.RawQuery != "" {
+		segments = append(segments, "?"+pageUrl.RawQuery)
+	}
+
+	return segments
+}
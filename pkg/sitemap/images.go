@@ -0,0 +1,116 @@
+package sitemap
+
+import (
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+const DefaultMaxImages = 1000
+
+type Image struct {
+	Location string `xml:"image:loc"`
+}
+
+var imgTagPattern = regexp.MustCompile(`(?is)<img\b[^>]*>`)
+var pictureBlockPattern = regexp.MustCompile(`(?is)<picture\b.*?</picture>`)
+var sourceTagPattern = regexp.MustCompile(`(?is)<source\b[^>]*>`)
+
+func (p *Parser) extractImages(html string, base *url.URL) []Image {
+	var candidates []string
+
+	for _, img := range findTags(imgTagPattern, html) {
+		candidates = append(candidates, img["src"])
+		candidates = append(candidates, srcsetURLs(img["srcset"])...)
+	}
+
+	for _, picture := range pictureBlockPattern.FindAllString(html, -1) {
+		for _, source := range findTags(sourceTagPattern, picture) {
+			candidates = append(candidates, srcsetURLs(source["srcset"])...)
+		}
+	}
+
+	limit := p.MaxImages
+	if limit <= 0 {
+		limit = DefaultMaxImages
+	}
+
+	var images []Image
+	seen := make(map[string]bool)
+
+	for _, candidate := range candidates {
+		if len(images) >= limit {
+			break
+		}
+
+		loc, ok := resolveURL(base, candidate)
+		if !ok || seen[loc.String()] || !p.isImageHost(loc.Hostname(), base.Hostname()) {
+			continue
+		}
+
+		seen[loc.String()] = true
+		images = append(images, Image{Location: loc.String()})
+	}
+
+	return images
+}
+
+func (p *Parser) isImageHost(host, pageHost string) bool {
+	host = strings.ToLower(host)
+	if host == strings.ToLower(pageHost) {
+		return true
+	}
+
+	for _, allowed := range p.ImageHosts {
+		allowed = strings.ToLower(allowed)
+		if host == allowed || strings.HasPrefix(allowed, "*.") && strings.HasSuffix(host, allowed[1:]) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// srcsetURLs returns the URL of every candidate in a srcset attribute,
+// dropping the width and density descriptors.
+func srcsetURLs(srcset string) []string {
+	var urls []string
+	for _, candidate := range strings.Split(srcset, ",") {
+		if fields := strings.Fields(candidate); len(fields) > 0 {
+			urls = append(urls, fields[0])
+		}
+	}
+	return urls
+}
+
+// resolveURL resolves ref against base and accepts only http(s) results.
+func resolveURL(base *url.URL, ref string) (*url.URL, bool) {
+	ref = strings.TrimSpace(ref)
+	if ref == "" {
+		return nil, false
+	}
+
+	u, err := url.Parse(ref)
+	if err != nil {
+		return nil, false
+	}
+
+	resolved := base.ResolveReference(u)
+	if resolved.Scheme != "http" && resolved.Scheme != "https" {
+		return nil, false
+	}
+	resolved.Fragment = ""
+
+	return resolved, true
+}
+
+// documentBase is the URL relative references on a page resolve against,
+// honouring a <base href> if the page declares one.
+func documentBase(html string, pageUrl *url.URL) *url.URL {
+	if match := basePattern.FindStringSubmatch(html); len(match) == 2 {
+		if base, err := url.Parse(strings.TrimSpace(match[1])); err == nil {
+			return pageUrl.ResolveReference(base)
+		}
+	}
+	return pageUrl
+}
@@ -0,0 +1,39 @@
+package sitemap
+
+import (
+	"bufio"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"time"
+)
+
+// IndexEntry is one sitemap listed in a sitemap index.
+type IndexEntry struct {
+	XMLName      xml.Name   `xml:"sitemap"`
+	Location     string     `xml:"loc"`
+	LastModified *time.Time `xml:"lastmod,omitempty"`
+}
+
+// WriteSitemapIndex writes a sitemapindex document listing entries,
+// referencing stylesheet from an xml-stylesheet processing instruction
+// when it is not empty.
+func WriteSitemapIndex(w io.Writer, stylesheet string, entries []IndexEntry) error {
+	out := bufio.NewWriter(w)
+
+	if stylesheet != "" {
+		fmt.Fprintln(out, StylesheetPI(stylesheet))
+	}
+	fmt.Fprintf(out, "<sitemapindex xmlns=\"%s\">\n", SitemapNamespace)
+
+	for _, entry := range entries {
+		data, err := xml.MarshalIndent(entry, " ", "  ")
+		if err != nil {
+			return err
+		}
+		out.Write(data)
+	}
+
+	out.WriteString("\n</sitemapindex>")
+	return out.Flush()
+}
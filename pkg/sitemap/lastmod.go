@@ -0,0 +1,160 @@
+package sitemap
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+type LastmodSource string
+
+const (
+	LastmodHeader LastmodSource = "header"
+	LastmodMeta   LastmodSource = "meta"
+	LastmodJSONLD LastmodSource = "jsonld"
+	LastmodTime   LastmodSource = "time"
+)
+
+var DefaultLastmodSources = []LastmodSource{LastmodHeader, LastmodMeta, LastmodJSONLD, LastmodTime}
+
+// W3CDatetime is the layout sitemaps use for lastmod.
+const W3CDatetime = "2006-01-02T15:04:05Z07:00"
+
+var dateLayouts = []string{
+	time.RFC1123,
+	time.RFC1123Z,
+	time.RFC850,
+	time.ANSIC,
+	time.RFC3339Nano,
+	"2006-01-02T15:04Z07:00",
+	"2006-01-02",
+	"2006-01",
+	"2006",
+}
+
+var lastmodMetaNames = []string{
+	"article:modified_time",
+	"og:updated_time",
+	"last-modified",
+	"dcterms.modified",
+	"dc.date.modified",
+	"datemodified",
+}
+
+func ParseLastmodSources(list string) ([]LastmodSource, error) {
+	sources := []LastmodSource{}
+
+	for _, name := range strings.Split(list, ",") {
+		source := LastmodSource(strings.ToLower(strings.TrimSpace(name)))
+		switch source {
+		case LastmodHeader, LastmodMeta, LastmodJSONLD, LastmodTime:
+			sources = append(sources, source)
+		case "":
+		default:
+			return nil, fmt.Errorf("unknown lastmod source %q", name)
+		}
+	}
+
+	return sources, nil
+}
+
+// ParseDate accepts the HTTP date formats (RFC 1123, RFC 850, asctime) and
+// the W3C Datetime profile of ISO 8601. Sub-second precision is dropped so
+// the result formats cleanly as W3CDatetime.
+func ParseDate(value string) (time.Time, error) {
+	value = strings.TrimSpace(value)
+
+	var firstErr error
+	for _, layout := range dateLayouts {
+		date, err := time.Parse(layout, value)
+		if err == nil {
+			return date.Truncate(time.Second), nil
+		}
+		if firstErr == nil {
+			firstErr = err
+		}
+	}
+
+	return time.Time{}, firstErr
+}
+
+func lastmodFromSources(sources []LastmodSource, resp *http.Response, page string) time.Time {
+	for _, source := range sources {
+		var date time.Time
+		var err error
+
+		switch source {
+		case LastmodHeader:
+			date, err = GetLastUpdatedDate(resp)
+		case LastmodMeta:
+			date, err = metaLastmod(page)
+		case LastmodJSONLD:
+			date, err = jsonLDLastmod(page)
+		case LastmodTime:
+			date, err = timeLastmod(page, fetchTime(resp))
+		}
+
+		if err == nil && !date.IsZero() {
+			return date
+		}
+	}
+
+	return time.Time{}
+}
+
+func metaLastmod(page string) (time.Time, error) {
+	values := make(map[string]string)
+	for _, meta := range findTags(metaTagPattern, page) {
+		for _, key := range []string{"property", "name", "itemprop", "http-equiv"} {
+			if name := strings.ToLower(meta[key]); name != "" {
+				values[name] = meta["content"]
+			}
+		}
+	}
+
+	for _, name := range lastmodMetaNames {
+		if value, ok := values[name]; ok {
+			return ParseDate(value)
+		}
+	}
+
+	return time.Time{}, ErrHeaderValueNotFound
+}
+
+func jsonLDLastmod(page string) (time.Time, error) {
+	for _, object := range jsonLDObjects(jsonLDDocuments(page)) {
+		if value := jsonLDString(object, "dateModified"); value != "" {
+			return ParseDate(value)
+		}
+	}
+
+	return time.Time{}, ErrHeaderValueNotFound
+}
+
+// timeLastmod picks the most recent <time datetime> on the page that isn't
+// after now, so dates of upcoming events aren't taken for a modification.
+func timeLastmod(page string, now time.Time) (time.Time, error) {
+	var latest time.Time
+	for _, tag := range findTags(timeTagPattern, page) {
+		date, err := ParseDate(tag["datetime"])
+		if err == nil && date.After(latest) && !date.After(now) {
+			latest = date
+		}
+	}
+
+	if latest.IsZero() {
+		return time.Time{}, ErrHeaderValueNotFound
+	}
+
+	return latest, nil
+}
+
+// fetchTime is when resp was sent according to its Date header, or the
+// current time if the server sent none.
+func fetchTime(resp *http.Response) time.Time {
+	if date, err := http.ParseTime(resp.Header.Get("Date")); err == nil {
+		return date
+	}
+	return time.Now()
+}
@@ -6,18 +6,44 @@ import (
 )
 
 type Page struct {
-	XMLName         xml.Name   `xml:"url"`
-	Location        string     `xml:"loc"`
-	LastModified    *time.Time `xml:"lastmod,omitempty"`
-	ChangeFrequency Frequency  `xml:"changefreq,omitempty"`
-	Priority        float64    `xml:"priority,omitempty"`
-	Depth           int        `xml:"-"`
-	Links           []string   `xml:"-"`
+	XMLName         xml.Name      `xml:"url"`
+	Location        string        `xml:"loc"`
+	LastModified    *time.Time    `xml:"lastmod,omitempty"`
+	ChangeFrequency Frequency     `xml:"changefreq,omitempty"`
+	Priority        float64       `xml:"priority,omitempty"`
+	Alternates      []Alternate   `xml:"xhtml:link,omitempty"`
+	Images          []Image       `xml:"image:image,omitempty"`
+	Videos          []Video       `xml:"video:video,omitempty"`
+	News            *News         `xml:"news:news,omitempty"`
+	Depth           int           `xml:"-"`
+	Links           []string      `xml:"-"`
+	StatusCode      int           `xml:"-"`
+	Size            int64         `xml:"-"`
+	FetchDuration   time.Duration `xml:"-"`
+	Validators      Validators    `xml:"-"`
+	NotModified     bool          `xml:"-"`
+	ContentHash     string        `xml:"-"`
+	Title           string        `xml:"-"`
+	Published       *time.Time    `xml:"-"`
+	ContentType     string        `xml:"-"`
+	Description     string        `xml:"-"`
+	Canonical       string        `xml:"-"`
+	InboundLinks    int           `xml:"-"`
+	Inclusion       Inclusion     `xml:"-"`
+	Anchors         []Anchor      `xml:"-"`
+	Redirects       []Hop         `xml:"-"`
 }
 
-var Extensions = []string{".png", ".jpg", ".jpeg", ".tiff", ".pdf", ".txt", ".gif", ".psd", ".ai", "dwg", ".bmp", ".zip", ".tar", ".gzip", ".svg", ".avi", ".mov", ".json", ".xml", ".mp3", ".wav", ".mid", ".ogg", ".acc", ".ac3", "mp4", ".ogm", ".cda", ".mpeg", ".avi", ".swf", ".acg", ".bat", ".ttf", ".msi", ".lnk", ".dll", ".db"}
+// Inclusion records whether a crawled page is listed in the sitemap and why.
+type Inclusion struct {
+	Included bool
+	Reason   string
+}
 
-var FalseUrls = []string{"mailto:", "javascript:", "tel:", "whatsapp:", "callto:", "wtai:", "sms:", "market:", "geopoint:", "ymsgr:", "msnim:", "gtalk:", "skype:"}
+type Validators struct {
+	ETag         string
+	LastModified string
+}
 
 var PriorityMap = map[int]float64{
 	1: 1.0,
@@ -30,3 +56,12 @@ var PriorityMap = map[int]float64{
 	8: 0.3,
 	9: 0.2,
 }
+
+// RequestedURL is the URL the page was requested as, before any redirects to
+// its Location.
+func (p Page) RequestedURL() string {
+	if len(p.Redirects) > 0 {
+		return p.Redirects[0].URL
+	}
+	return p.Location
+}
@@ -0,0 +1,69 @@
+package sitemap
+
+import (
+	"regexp"
+	"strings"
+	"time"
+)
+
+const (
+	MaxNewsURLs = 1000
+	NewsMaxAge  = 48 * time.Hour
+)
+
+var NewsExtension = Extension{Prefix: "news", Namespace: "http://www.google.com/schemas/sitemap-news/0.9"}
+
+type News struct {
+	Publication     Publication `xml:"news:publication"`
+	PublicationDate time.Time   `xml:"news:publication_date"`
+	Title           string      `xml:"news:title"`
+}
+
+type Publication struct {
+	Name     string `xml:"news:name"`
+	Language string `xml:"news:language"`
+}
+
+var publishedMetaNames = []string{
+	"article:published_time",
+	"og:published_time",
+	"datepublished",
+	"dcterms.created",
+	"dc.date.issued",
+	"pubdate",
+}
+
+var pubdateTimePattern = regexp.MustCompile(`(?is)<time\b[^>]*\bpubdate\b[^>]*>`)
+
+// PublicationDate returns when an article was first published according to
+// its metadata: meta tags, JSON-LD datePublished, or <time pubdate>.
+func PublicationDate(page string) (time.Time, bool) {
+	values := make(map[string]string)
+	for _, meta := range findTags(metaTagPattern, page) {
+		for _, key := range []string{"property", "name", "itemprop"} {
+			if name := strings.ToLower(meta[key]); name != "" {
+				values[name] = meta["content"]
+			}
+		}
+	}
+
+	for _, name := range publishedMetaNames {
+		if date, err := ParseDate(values[name]); err == nil {
+			return date, true
+		}
+	}
+
+	for _, object := range jsonLDObjects(jsonLDDocuments(page)) {
+		if date, err := ParseDate(jsonLDString(object, "datePublished")); err == nil {
+			return date, true
+		}
+	}
+
+	for _, tag := range findTags(pubdateTimePattern, page) {
+		if date, err := ParseDate(tag["datetime"]); err == nil {
+			return date, true
+		}
+	}
+
+	return time.Time{}, false
+}
@@ -0,0 +1,189 @@
+package sitemap
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// PriorityStrategy assigns Priority to crawled pages. It runs once the crawl
+// is complete so strategies can use the whole internal link graph.
+type PriorityStrategy interface {
+	Assign(pages []Page)
+}
+
+// DefaultPriorityFloor is the lowest priority assigned by the built-in
+// strategies, so deep or rarely linked pages are not left without one and
+// read as the protocol's default of 0.5.
+const DefaultPriorityFloor = 0.1
+
+// DepthPriority looks a page's depth up in Table, using Floor for depths
+// missing from it.
+type DepthPriority struct {
+	Table map[int]float64
+	Floor float64
+}
+
+func (s DepthPriority) Assign(pages []Page) {
+	table := s.Table
+	if table == nil {
+		table = PriorityMap
+	}
+
+	for i := range pages {
+		priority, ok := table[pages[i].Depth]
+		if !ok || priority < s.Floor {
+			priority = s.Floor
+		}
+		pages[i].Priority = priority
+	}
+}
+
+// ParsePriorityTable reads a depth table such as "1:1.0,2:0.8,3:0.5".
+func ParsePriorityTable(list string) (map[int]float64, error) {
+	table := make(map[int]float64)
+	for _, item := range strings.Split(list, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+
+		parts := strings.SplitN(item, ":", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("priority table entry %q isn't depth:priority", item)
+		}
+
+		depth, err := strconv.Atoi(strings.TrimSpace(parts[0]))
+		if err != nil || depth < 1 {
+			return nil, fmt.Errorf("invalid depth in priority table entry %q", item)
+		}
+		priority, err := strconv.ParseFloat(strings.TrimSpace(parts[1]), 64)
+		if err != nil || priority < 0 || priority > 1 {
+			return nil, fmt.Errorf("invalid priority in priority table entry %q", item)
+		}
+
+		table[depth] = priority
+	}
+
+	return table, nil
+}
+
+// InboundLinkPriority ranks pages by how many other crawled pages link to
+// them, on a logarithmic scale from Floor for the least linked page to 1.0
+// for the most linked one.
+type InboundLinkPriority struct {
+	Floor float64
+}
+
+func (s InboundLinkPriority) Assign(pages []Page) {
+	CountInboundLinks(pages)
+
+	scores := make([]float64, len(pages))
+	for i, page := range pages {
+		scores[i] = math.Log1p(float64(page.InboundLinks))
+	}
+
+	assignScaled(pages, scores, s.Floor)
+}
+
+// PageRankPriority ranks pages by their PageRank over the internal link
+// graph, scaled from Floor for the lowest ranked page to 1.0 for the highest.
+type PageRankPriority struct {
+	Floor float64
+	// Damping is the probability of following a link, 0.85 if zero.
+	Damping float64
+	// Iterations bounds the power iteration, 100 if zero.
+	Iterations int
+}
+
+func (s PageRankPriority) Assign(pages []Page) {
+	assignScaled(pages, PageRank(pages, s.Damping, s.Iterations), s.Floor)
+}
+
+// PageRank computes the PageRank of every page over the links between the
+// given pages, iterating until the ranks change by less than 1e-9 or
+// iterations is reached. Rank of pages without links is spread evenly.
+func PageRank(pages []Page, damping float64, iterations int) []float64 {
+	if damping == 0 {
+		damping = 0.85
+	}
+	if iterations == 0 {
+		iterations = 100
+	}
+
+	n := len(pages)
+	if n == 0 {
+		return nil
+	}
+
+	index := make(map[string]int, n)
+	for i, page := range pages {
+		index[page.Location] = i
+	}
+
+	outbound := make([][]int, n)
+	for i, page := range pages {
+		linked := make(map[int]bool)
+		for _, link := range page.Links {
+			if j, ok := index[link]; ok && j != i && !linked[j] {
+				linked[j] = true
+				outbound[i] = append(outbound[i], j)
+			}
+		}
+	}
+
+	rank := make([]float64, n)
+	for i := range rank {
+		rank[i] = 1 / float64(n)
+	}
+
+	for iteration := 0; iteration < iterations; iteration++ {
+		dangling := 0.0
+		for i := range pages {
+			if len(outbound[i]) == 0 {
+				dangling += rank[i]
+			}
+		}
+
+		next := make([]float64, n)
+		for i := range next {
+			next[i] = (1-damping)/float64(n) + damping*dangling/float64(n)
+		}
+		for i, targets := range outbound {
+			share := damping * rank[i] / float64(len(targets))
+			for _, j := range targets {
+				next[j] += share
+			}
+		}
+
+		delta := 0.0
+		for i := range rank {
+			delta += math.Abs(next[i] - rank[i])
+		}
+		rank = next
+		if delta < 1e-9 {
+			break
+		}
+	}
+
+	return rank
+}
+
+// assignScaled sets priorities scaled linearly from floor for the lowest
+// score to 1.0 for the highest, rounded to one decimal so small changes in the
+// link graph don't change the sitemap.
+func assignScaled(pages []Page, scores []float64, floor float64) {
+	min, max := math.Inf(1), math.Inf(-1)
+	for _, score := range scores {
+		min, max = math.Min(min, score), math.Max(max, score)
+	}
+
+	for i := range pages {
+		priority := 1.0
+		if max > min {
+			priority = floor + (1-floor)*(scores[i]-min)/(max-min)
+		}
+		pages[i].Priority = math.Max(floor, math.Round(priority*10)/10)
+	}
+}
@@ -0,0 +1,236 @@
+package sitemap
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// DefaultMaxRedirects is how many redirects are followed from one URL, as
+// net/http does.
+const DefaultMaxRedirects = 10
+
+var ErrRedirectLoop = errors.New("redirect loop")
+var ErrTooManyRedirects = errors.New("too many redirects")
+
+// Redirect issue kinds reported by AuditRedirects.
+const (
+	RedirectLongChain = "long-chain"
+	RedirectLoop      = "loop"
+	RedirectCrossHost = "cross-host"
+	RedirectTemporary = "temporary"
+)
+
+// Hop is a redirect answered for URL with Status.
+type Hop struct {
+	URL    string
+	Status int
+}
+
+// IsPermanent reports whether the hop is a 301 or 308 redirect.
+func (h Hop) IsPermanent() bool {
+	return h.Status == http.StatusMovedPermanently || h.Status == http.StatusPermanentRedirect
+}
+
+// RedirectChain is the redirects followed from a requested URL up to the
+// Final URL, or up to the redirect to Final that failed with Err.
+type RedirectChain struct {
+	Hops  []Hop
+	Final string
+	Err   error
+}
+
+func (chain RedirectChain) String() string {
+	var b strings.Builder
+	for _, hop := range chain.Hops {
+		fmt.Fprintf(&b, "%s -%d-> ", hop.URL, hop.Status)
+	}
+	b.WriteString(chain.Final)
+	return b.String()
+}
+
+// redirectChainJSON is a RedirectChain with its error as text.
+type redirectChainJSON struct {
+	Hops  []Hop
+	Final string
+	Err   string `json:",omitempty"`
+}
+
+func (chain RedirectChain) MarshalJSON() ([]byte, error) {
+	data := redirectChainJSON{Hops: chain.Hops, Final: chain.Final}
+	if chain.Err != nil {
+		data.Err = chain.Err.Error()
+	}
+	return json.Marshal(data)
+}
+
+// UnmarshalJSON restores the redirect errors of this package, so a decoded
+// chain is audited like the one that was encoded.
+func (chain *RedirectChain) UnmarshalJSON(b []byte) error {
+	var data redirectChainJSON
+	if err := json.Unmarshal(b, &data); err != nil {
+		return err
+	}
+
+	*chain = RedirectChain{Hops: data.Hops, Final: data.Final}
+	if data.Err == "" {
+		return nil
+	}
+
+	chain.Err = errors.New(data.Err)
+	for _, err := range []error{ErrRedirectLoop, ErrTooManyRedirects, ErrNotSameHost} {
+		if data.Err == err.Error() {
+			chain.Err = err
+		}
+	}
+	return nil
+}
+
+// RedirectError is a redirect chain that wasn't followed to the end: a loop,
+// a chain longer than the redirect limit or a redirect off the crawled hosts,
+// which unwraps to ErrNotSameHost.
+type RedirectError struct {
+	Chain RedirectChain
+}
+
+func (e *RedirectError) Error() string {
+	reason := e.Chain.Err.Error()
+	if errors.Is(e.Chain.Err, ErrNotSameHost) {
+		reason = "dropped redirect to another host"
+	}
+	return fmt.Sprintf("%s: %s", reason, e.Chain)
+}
+
+func (e *RedirectError) Unwrap() error {
+	return e.Chain.Err
+}
+
+// noRedirectClient hands redirects back to fetch instead of following them.
+var noRedirectClient = &http.Client{
+	CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	},
+}
+
+func isRedirect(status int) bool {
+	switch status {
+	case http.StatusMovedPermanently, http.StatusFound, http.StatusSeeOther,
+		http.StatusTemporaryRedirect, http.StatusPermanentRedirect:
+		return true
+	}
+	return false
+}
+
+// fetch requests URL and follows its redirects one hop at a time, so that each
+// hop is recorded and loops, overlong chains and redirects to hosts outside
+// the crawl are stopped with a *RedirectError. Each request carries the
+// validators saved for the URL it requests.
+func (p *Parser) fetch(URL string, validators ValidatorsFunc) (*http.Response, []Hop, error) {
+	var hops []Hop
+	var origin string
+	visited := map[string]bool{URL: true}
+	current := URL
+
+	for {
+		resp, err := doRequest(noRedirectClient, current, validators(current))
+		if err != nil {
+			return nil, hops, err
+		}
+		if origin == "" {
+			origin = resp.Request.URL.Hostname()
+		}
+
+		location, err := resp.Location()
+		if !isRedirect(resp.StatusCode) || err != nil {
+			return resp, hops, nil
+		}
+		resp.Body.Close()
+
+		hops = append(hops, Hop{URL: current, Status: resp.StatusCode})
+		target := location.String()
+
+		switch {
+		case visited[target]:
+			err = ErrRedirectLoop
+		case len(hops) > p.maxRedirects():
+			err = ErrTooManyRedirects
+		case !p.allowsHost(origin, location.Hostname()):
+			err = ErrNotSameHost
+		}
+		if err != nil {
+			return nil, hops, &RedirectError{Chain: RedirectChain{Hops: hops, Final: target, Err: err}}
+		}
+
+		visited[target] = true
+		current = target
+	}
+}
+
+func (p *Parser) maxRedirects() int {
+	if p.MaxRedirects == 0 {
+		return DefaultMaxRedirects
+	}
+	return p.MaxRedirects
+}
+
+// RedirectIssue is a redirect chain worth fixing and why.
+type RedirectIssue struct {
+	Kind  string
+	Chain RedirectChain
+}
+
+func (issue RedirectIssue) String() string {
+	return fmt.Sprintf("%s: %s", issue.Kind, issue.Chain)
+}
+
+// AuditRedirects reports the loops and cross-host redirects among chains,
+// chains of more than maxHops redirects, and chains using temporary
+// redirects, which search engines may not treat as moved. A maxHops of 0
+// disables the chain length check.
+func AuditRedirects(chains []RedirectChain, maxHops int) []RedirectIssue {
+	var issues []RedirectIssue
+	for _, chain := range chains {
+		switch {
+		case errors.Is(chain.Err, ErrRedirectLoop):
+			issues = append(issues, RedirectIssue{Kind: RedirectLoop, Chain: chain})
+			continue
+		case errors.Is(chain.Err, ErrNotSameHost):
+			issues = append(issues, RedirectIssue{Kind: RedirectCrossHost, Chain: chain})
+		}
+
+		if maxHops > 0 && len(chain.Hops) > maxHops {
+			issues = append(issues, RedirectIssue{Kind: RedirectLongChain, Chain: chain})
+		}
+
+		for _, hop := range chain.Hops {
+			if !hop.IsPermanent() {
+				issues = append(issues, RedirectIssue{Kind: RedirectTemporary, Chain: chain})
+				break
+			}
+		}
+	}
+
+	sort.SliceStable(issues, func(i, j int) bool {
+		if issues[i].Kind != issues[j].Kind {
+			return issues[i].Kind < issues[j].Kind
+		}
+		return issues[i].Chain.String() < issues[j].Chain.String()
+	})
+
+	return issues
+}
+
+// RedirectUsage counts the hops of chains by status code, e.g. to compare how
+// often 302 is used instead of 301.
+func RedirectUsage(chains []RedirectChain) map[int]int {
+	usage := make(map[int]int)
+	for _, chain := range chains {
+		for _, hop := range chain.Hops {
+			usage[hop.Status]++
+		}
+	}
+	return usage
+}
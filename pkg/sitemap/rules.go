@@ -0,0 +1,213 @@
+package sitemap
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"path/filepath"
+	"strings"
+)
+
+// Rule overrides sitemap attributes of the URLs matching Pattern.
+type Rule struct {
+	Pattern    *Pattern
+	Priority   *float64
+	ChangeFreq Frequency
+	Exclude    bool
+	Lastmod    []LastmodSource
+
+	// Line is where the rule starts in its rules file.
+	Line int
+}
+
+// Rules are evaluated in order; the first rule matching a URL applies.
+type Rules []Rule
+
+type ruleJSON struct {
+	Pattern    string   `json:"pattern"`
+	Priority   *float64 `json:"priority"`
+	ChangeFreq string   `json:"changefreq"`
+	Exclude    bool     `json:"exclude"`
+	Lastmod    []string `json:"lastmod"`
+}
+
+// LoadRules reads a rules file, see ParseRules.
+func LoadRules(path string) (Rules, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return ParseRules(filepath.Base(path), data)
+}
+
+// ParseRules reads rules from a JSON document of the form
+//
+//	{"rules": [{"pattern": "/blog/*", "priority": 0.8, "changefreq": "weekly"}]}
+//
+// Every rule has a pattern, as accepted by ParsePattern, and at least one of
+// priority, changefreq, exclude or lastmod (a list of lastmod sources).
+// Errors are reported as name:line.
+func ParseRules(name string, data []byte) (Rules, error) {
+	fail := func(offset int64, format string, args ...interface{}) error {
+		return fmt.Errorf("%s:%d: %s", name, lineAt(data, offset), fmt.Sprintf(format, args...))
+	}
+
+	var syntax interface{}
+	if err := json.Unmarshal(data, &syntax); err != nil {
+		var syntaxErr *json.SyntaxError
+		if errors.As(err, &syntaxErr) {
+			return nil, fail(syntaxErr.Offset, "%v", err)
+		}
+		return nil, fail(0, "%v", err)
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(data))
+	if tok, _ := dec.Token(); tok != json.Delim('{') {
+		return nil, fail(dec.InputOffset(), `expected an object with a "rules" list`)
+	}
+
+	var rules Rules
+	for dec.More() {
+		key, _ := dec.Token()
+		if key != "rules" {
+			return nil, fail(dec.InputOffset(), "unknown key %q", key)
+		}
+
+		if tok, _ := dec.Token(); tok != json.Delim('[') {
+			return nil, fail(dec.InputOffset(), `"rules" must be a list`)
+		}
+
+		for dec.More() {
+			start := skipSeparators(data, dec.InputOffset())
+
+			var raw json.RawMessage
+			if err := dec.Decode(&raw); err != nil {
+				return nil, fail(start, "%v", err)
+			}
+
+			rule, field, err := parseRule(raw)
+			if err != nil {
+				offset := start
+				if i := bytes.Index(raw, []byte(`"`+field+`"`)); field != "" && i >= 0 {
+					offset += int64(i)
+				}
+				return nil, fail(offset, "%v", err)
+			}
+
+			rule.Line = lineAt(data, start)
+			rules = append(rules, rule)
+		}
+
+		dec.Token()
+	}
+
+	return rules, nil
+}
+
+// parseRule validates a single rule, returning the name of the offending
+// field with any error.
+func parseRule(raw json.RawMessage) (Rule, string, error) {
+	var r ruleJSON
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&r); err != nil {
+		var typeErr *json.UnmarshalTypeError
+		if errors.As(err, &typeErr) {
+			return Rule{}, typeErr.Field, fmt.Errorf("%s must be a %s", typeErr.Field, typeErr.Type)
+		}
+		if field := strings.TrimPrefix(err.Error(), "json: unknown field "); field != err.Error() {
+			return Rule{}, strings.Trim(field, `"`), fmt.Errorf("unknown field %s", field)
+		}
+		return Rule{}, "", fmt.Errorf("a rule must be an object: %v", err)
+	}
+
+	if r.Pattern == "" {
+		return Rule{}, "", errors.New("rule without a pattern")
+	}
+	pattern, err := ParsePattern(r.Pattern)
+	if err != nil {
+		return Rule{}, "pattern", fmt.Errorf("invalid pattern %q: %v", r.Pattern, err)
+	}
+	rule := Rule{Pattern: pattern, Priority: r.Priority, Exclude: r.Exclude}
+
+	if r.Priority != nil && (*r.Priority < 0 || *r.Priority > 1) {
+		return Rule{}, "priority", fmt.Errorf("priority %v isn't between 0 and 1", *r.Priority)
+	}
+
+	if r.ChangeFreq != "" {
+		freq, err := ParseFrequency(r.ChangeFreq)
+		if err != nil {
+			return Rule{}, "changefreq", err
+		}
+		rule.ChangeFreq = freq
+	}
+
+	if r.Lastmod != nil {
+		sources, err := ParseLastmodSources(strings.Join(r.Lastmod, ","))
+		if err != nil {
+			return Rule{}, "lastmod", err
+		}
+		rule.Lastmod = sources
+	}
+
+	if r.Priority == nil && r.ChangeFreq == "" && !r.Exclude && r.Lastmod == nil {
+		return Rule{}, "pattern", fmt.Errorf("rule for %q sets none of priority, changefreq, exclude or lastmod", r.Pattern)
+	}
+
+	return rule, "", nil
+}
+
+func lineAt(data []byte, offset int64) int {
+	if offset > int64(len(data)) {
+		offset = int64(len(data))
+	}
+	return 1 + bytes.Count(data[:offset], []byte("\n"))
+}
+
+// skipSeparators moves offset past whitespace and the comma between two
+// list elements.
+func skipSeparators(data []byte, offset int64) int64 {
+	for offset < int64(len(data)) && strings.ContainsRune(" \t\r\n,", rune(data[offset])) {
+		offset++
+	}
+	return offset
+}
+
+// Match returns the first rule matching rawURL, or nil.
+func (r Rules) Match(rawURL string) *Rule {
+	if len(r) == 0 {
+		return nil
+	}
+
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil
+	}
+
+	for i := range r {
+		if r[i].Pattern.Match(u) {
+			return &r[i]
+		}
+	}
+	return nil
+}
+
+// Apply sets the priority and changefreq of the first matching rule on every
+// page.
+func (r Rules) Apply(pages []Page) {
+	for i := range pages {
+		rule := r.Match(pages[i].Location)
+		if rule == nil {
+			continue
+		}
+		if rule.Priority != nil {
+			pages[i].Priority = *rule.Priority
+		}
+		if rule.ChangeFreq != None {
+			pages[i].ChangeFrequency = rule.ChangeFreq
+		}
+	}
+}
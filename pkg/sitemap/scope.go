@@ -0,0 +1,125 @@
+package sitemap
+
+import (
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// ScopeDecision says what the crawler does with a URL.
+type ScopeDecision int
+
+const (
+	// ScopeList crawls the URL and lists it in the sitemap.
+	ScopeList ScopeDecision = iota
+	// ScopeCrawlOnly crawls the URL to follow its links but leaves it out of
+	// the sitemap.
+	ScopeCrawlOnly
+	// ScopeSkip neither crawls nor lists the URL.
+	ScopeSkip
+)
+
+func (d ScopeDecision) String() string {
+	return [...]string{"list", "crawl-only", "skip"}[d]
+}
+
+// ScopeFunc decides whether a URL is crawled and listed. Parser.Scope accepts
+// any ScopeFunc; ScopeRules.Decide is the pattern based implementation.
+type ScopeFunc func(u *url.URL) ScopeDecision
+
+// Pattern matches URLs by their path and query. Patterns prefixed with "re:"
+// are regular expressions, searched anywhere in the path and query; any other
+// pattern is a glob where * matches any run of characters and the whole path
+// and query must match.
+type Pattern struct {
+	raw string
+	re  *regexp.Regexp
+}
+
+func ParsePattern(pattern string) (*Pattern, error) {
+	if expr := strings.TrimPrefix(pattern, "re:"); expr != pattern {
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			return nil, err
+		}
+		return &Pattern{raw: pattern, re: re}, nil
+	}
+
+	expr := strings.ReplaceAll(regexp.QuoteMeta(pattern), `\*`, `.*`)
+	return &Pattern{raw: pattern, re: regexp.MustCompile("^" + expr + "$")}, nil
+}
+
+func (p *Pattern) Match(u *url.URL) bool {
+	target := u.EscapedPath()
+	if target == "" {
+		target = "/"
+	}
+	if u.RawQuery != "" {
+		target += "?" + u.RawQuery
+	}
+
+	return p.re.MatchString(target)
+}
+
+func (p *Pattern) String() string {
+	return p.raw
+}
+
+// ScopeRules is a pattern based scope. When Include is set only URLs
+// matching one of its patterns are crawled; URLs matching Exclude are never
+// crawled and URLs matching NoList are crawled but not listed.
+type ScopeRules struct {
+	Include []*Pattern
+	Exclude []*Pattern
+	NoList  []*Pattern
+}
+
+func (r ScopeRules) Decide(u *url.URL) ScopeDecision {
+	if len(r.Include) > 0 && !matchAny(r.Include, u) {
+		return ScopeSkip
+	}
+	if matchAny(r.Exclude, u) {
+		return ScopeSkip
+	}
+	if matchAny(r.NoList, u) {
+		return ScopeCrawlOnly
+	}
+	return ScopeList
+}
+
+func matchAny(patterns []*Pattern, u *url.URL) bool {
+	for _, pattern := range patterns {
+		if pattern.Match(u) {
+			return true
+		}
+	}
+	return false
+}
+
+// Decide applies the parser's Scope to rawURL. Every URL is listed when no
+// scope is set, even on a nil Parser; URLs that do not parse are skipped.
+func (p *Parser) Decide(rawURL string) ScopeDecision {
+	if p == nil || p.Scope == nil {
+		return ScopeList
+	}
+
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return ScopeSkip
+	}
+	return p.Scope(u)
+}
+
+func (p *Parser) scopeLinks(links []string) []string {
+	if p.Scope == nil {
+		return links
+	}
+
+	var scoped []string
+	for _, link := range links {
+		if p.Decide(link) != ScopeSkip {
+			scoped = append(scoped, link)
+		}
+	}
+	return scoped
+}
@@ -11,6 +11,7 @@ import (
 )
 
 var ErrHeaderValueNotFound = errors.New("last-moodified Header value not found")
+var ErrNotSameHost = errors.New("not same host")
 
 var basePattern = regexp.MustCompile(`<base[\s\S]*?href="([^"]+)"[\s\S]*?>`)
 var hrefPattern = regexp.MustCompile(`<a[\s\S]*?href="([^"]+)"[\s\S]*?>`)
@@ -21,7 +22,7 @@ func GetLastUpdatedDate(resp *http.Response) (time.Time, error) {
 		return time.Time{}, ErrHeaderValueNotFound
 	}
 
-	date, err := time.Parse(time.RFC1123, lastModified)
+	date, err := ParseDate(lastModified)
 	if err != nil {
 		return time.Time{}, err
 	}
@@ -30,9 +31,6 @@ func GetLastUpdatedDate(resp *http.Response) (time.Time, error) {
 }
 
 func GetLinks(resp *http.Response) ([]string, error) {
-	hostname := resp.Request.URL.Hostname()
-	root := resp.Request.URL.Scheme + "://" + resp.Request.URL.Hostname()
-
 	defer resp.Body.Close()
 
 	html, err := ioutil.ReadAll(resp.Body)
@@ -40,19 +38,41 @@ func GetLinks(resp *http.Response) ([]string, error) {
 		return nil, err
 	}
 
-	baseMatches := basePattern.FindStringSubmatch(string(html))
+	return extractLinks(string(html), resp.Request.URL), nil
+}
+
+func extractLinks(html string, pageUrl *url.URL) []string {
+	return extractScopedLinks(html, pageUrl, nil, DefaultLinkFilter)
+}
+
+// extractScopedLinks returns the links of html that point at hosts the scope
+// allows, or at pageUrl's own host when scope is nil, and pass filter.
+func extractScopedLinks(html string, pageUrl *url.URL, scope *HostScope, filter *LinkFilter) []string {
+	return anchorURLs(extractScopedAnchors(html, pageUrl, scope, filter))
+}
+
+// extractScopedAnchors is extractScopedLinks keeping the text of each link.
+func extractScopedAnchors(html string, pageUrl *url.URL, scope *HostScope, filter *LinkFilter) []Anchor {
+	hostname := pageUrl.Hostname()
+	root := pageUrl.Scheme + "://" + pageUrl.Hostname()
+
+	baseMatches := basePattern.FindStringSubmatch(html)
 
 	baseUrl := ""
 	if len(baseMatches) == 2 {
 		baseUrl = baseMatches[1]
 	}
 
-	matches := hrefPattern.FindAllStringSubmatch(string(html), -1)
+	matches := hrefPattern.FindAllStringSubmatchIndex(html, -1)
 
-	var links []string
+	var anchors []Anchor
 
 	for _, match := range matches {
-		foundLink := SanitizeUrl(match[1])
+		foundLink := SanitizeUrl(html[match[2]:match[3]])
+
+		if parsed, err := url.Parse(foundLink); err != nil || !filter.AllowsScheme(parsed.Scheme) {
+			continue
+		}
 
 		if strings.HasPrefix(foundLink, "//") {
 		} else if strings.HasPrefix(foundLink, "/") {
@@ -61,67 +81,233 @@ func GetLinks(resp *http.Response) ([]string, error) {
 			foundLink = baseUrl + foundLink
 		}
 
-		if isValidLink(foundLink, hostname) {
-			links = append(links, foundLink)
+		if isValidScopedLink(foundLink, hostname, scope, filter) {
+			anchors = append(anchors, Anchor{URL: foundLink, Text: anchorText(html[match[1]:])})
 
 		}
 	}
 
-	return links, nil
+	return anchors
 }
 
-func doRequest(url string) (*http.Response, error) {
-	return http.Get(url)
+func doRequest(client *http.Client, url string, validators Validators) (*http.Response, error) {
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	if validators.ETag != "" {
+		req.Header.Set("If-None-Match", validators.ETag)
+	}
+	if validators.LastModified != "" {
+		req.Header.Set("If-Modified-Since", validators.LastModified)
+	}
 
+	return client.Do(req)
+}
+
+func GetValidators(resp *http.Response) Validators {
+	return Validators{
+		ETag:         resp.Header.Get("ETag"),
+		LastModified: resp.Header.Get("Last-Modified"),
+	}
+}
+
+type Parser struct {
+	LastmodSources []LastmodSource
+
+	Images     bool
+	MaxImages  int
+	ImageHosts []string
+
+	Videos bool
+
+	Hreflang bool
+
+	// Scope, when set, drops links to skipped URLs from Page.Links so they
+	// are not crawled.
+	Scope ScopeFunc
+
+	// Hosts decides which hosts links are followed to. Only the page's own
+	// host is followed when nil.
+	Hosts *HostScope
+
+	// Filter drops links by scheme and file extension. DefaultLinkFilter is
+	// used when nil.
+	Filter *LinkFilter
+
+	// Rules may override LastmodSources for the URLs they match.
+	Rules Rules
+
+	// MaxRedirects is how many redirects are followed from a URL.
+	// DefaultMaxRedirects is used when 0.
+	MaxRedirects int
 }
 
 func extractData(resp *http.Response, URL string) (Page, error) {
-	baseUrl, err := url.Parse(URL)
+	return new(Parser).extractData(resp, URL)
+}
+
+func (p *Parser) extractData(resp *http.Response, URL string) (Page, error) {
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
 	if err != nil {
 		return Page{}, err
 	}
+	html := string(body)
+	lastModified := lastmodFromSources(p.lastmodSourcesFor(URL), resp, html)
+	anchors := extractScopedAnchors(html, resp.Request.URL, p.Hosts, p.linkFilter())
 
-	if resp.Request.URL.Hostname() != baseUrl.Hostname() {
-		return Page{}, errors.New("not same host")
+	page := Page{
+		Location:    URL,
+		Links:       p.scopeLinks(anchorURLs(anchors)),
+		Anchors:     anchors,
+		StatusCode:  resp.StatusCode,
+		Size:        int64(len(body)),
+		Validators:  GetValidators(resp),
+		ContentHash: ContentHash(html),
+		Title:       PageTitle(html),
+		ContentType: resp.Header.Get("Content-Type"),
+		Description: MetaDescription(html),
 	}
 
-	lastModified, err := GetLastUpdatedDate(resp)
-	if err != nil && err != ErrHeaderValueNotFound {
-		return Page{}, err
+	// Without a date from any lastmod source the page has no lastmod, rather
+	// than the zero time.
+	if !lastModified.IsZero() {
+		page.LastModified = &lastModified
 	}
 
-	links, err := GetLinks(resp)
-	if err != nil {
-		return Page{}, err
+	if published, ok := PublicationDate(html); ok {
+		page.Published = &published
 	}
 
-	page := Page{
-		Location:     URL,
-		LastModified: &lastModified,
-		Links:        links,
+	base := documentBase(html, resp.Request.URL)
+	page.Canonical = canonicalURL(html, base)
+	if p.Images {
+		page.Images = p.extractImages(html, base)
+	}
+	if p.Videos {
+		page.Videos = p.extractVideos(html, base)
+	}
+	if p.Hreflang {
+		page.Alternates = extractAlternates(resp, html, base)
 	}
 
 	return page, nil
 }
 
+// allowsHost reports whether a page requested on host may be redirected to
+// final.
+func (p *Parser) allowsHost(host, final string) bool {
+	if p.Hosts == nil {
+		return host == final
+	}
+	return p.Hosts.Allows(final)
+}
+
+func (p *Parser) linkFilter() *LinkFilter {
+	if p.Filter == nil {
+		return DefaultLinkFilter
+	}
+	return p.Filter
+}
+
+func (p *Parser) lastmodSourcesFor(URL string) []LastmodSource {
+	if rule := p.Rules.Match(URL); rule != nil && rule.Lastmod != nil {
+		return rule.Lastmod
+	}
+	return p.lastmodSources()
+}
+
+func (p *Parser) lastmodSources() []LastmodSource {
+	if p.LastmodSources == nil {
+		return DefaultLastmodSources
+	}
+	return p.LastmodSources
+}
+
 func ParsePage(URL string) (Page, error) {
+	return new(Parser).Parse(URL, Validators{})
+}
+
+func ParsePageIfModified(URL string, validators Validators) (Page, error) {
+	return new(Parser).Parse(URL, validators)
+}
+
+// ValidatorsFunc returns the validators saved for URL, if any.
+type ValidatorsFunc func(URL string) Validators
+
+// Parse fetches URL, sending validators as a conditional request. When the
+// server answers 304 the returned page only carries its location, status and
+// validators, and NotModified is set. A redirected page is located at the
+// final URL and lists the redirects followed in Redirects. validators are
+// only sent to URL itself, not to the URLs it redirects to.
+func (p *Parser) Parse(URL string, validators Validators) (Page, error) {
+	return p.ParseConditional(URL, func(requested string) Validators {
+		if requested == URL {
+			return validators
+		}
+		return Validators{}
+	})
+}
+
+// ParseConditional is Parse sending every request, including each redirect
+// followed, the validators saved for the URL requested. validators may be nil.
+func (p *Parser) ParseConditional(URL string, validators ValidatorsFunc) (Page, error) {
+	if validators == nil {
+		validators = func(string) Validators { return Validators{} }
+	}
 
-	resp, err := doRequest(URL)
+	start := time.Now()
+
+	resp, hops, err := p.fetch(URL, validators)
 	if err != nil {
 		return Page{}, err
 	}
 
-	return extractData(resp, URL)
+	final := URL
+	if len(hops) > 0 {
+		final = resp.Request.URL.String()
+	}
+
+	if resp.StatusCode == http.StatusNotModified {
+		resp.Body.Close()
+		page := notModifiedPage(resp, final, validators(final), time.Since(start))
+		page.Redirects = hops
+		return page, nil
+	}
+
+	page, err := p.extractData(resp, final)
+	if err != nil {
+		return Page{}, err
+	}
+
+	page.FetchDuration = time.Since(start)
+	page.Redirects = hops
+
+	return page, nil
 }
 
-func SanitizeUrl(link string) string {
+func notModifiedPage(resp *http.Response, URL string, previous Validators, elapsed time.Duration) Page {
+	validators := GetValidators(resp)
+	if validators.ETag == "" {
+		validators.ETag = previous.ETag
+	}
+	if validators.LastModified == "" {
+		validators.LastModified = previous.LastModified
+	}
 
-	for _, fal := range FalseUrls {
-		if strings.Contains(link, fal) {
-			return ""
-		}
+	return Page{
+		Location:      URL,
+		StatusCode:    resp.StatusCode,
+		FetchDuration: elapsed,
+		Validators:    validators,
+		NotModified:   true,
 	}
+}
 
+func SanitizeUrl(link string) string {
 	link = strings.TrimSpace(link)
 	tram := strings.Split(link, "#")[0]
 
@@ -142,21 +328,24 @@ func isStart(link, root string) bool {
 	return strings.Compare(link, root) == 0
 }
 
-func isValidExtension(link string) bool {
-	preparedLink := strings.TrimSuffix(strings.ToLower(link), "/")
-	for _, extension := range Extensions {
-		if strings.HasSuffix(preparedLink, extension) {
-			return false
-		}
+func isValidScopedLink(link, root string, scope *HostScope, filter *LinkFilter) bool {
+	url, err := url.Parse(link)
+	if err != nil || !filter.Allows(url) {
+		return false
 	}
-	return true
-}
 
-func isValidLink(link, root string) bool {
-
-	if isInternLink(link, root) && !isStart(link, root) && isValidExtension(link) {
-		return true
+	if scope == nil {
+		return isInternLink(link, root) && !isStart(link, root)
 	}
 
-	return false
+	return scope.Allows(url.Hostname()) && !isStart(link, root)
+}
+
+func canonicalURL(html string, base *url.URL) string {
+	for _, link := range findTags(linkTagPattern, html) {
+		if hasToken(link["rel"], "canonical") {
+			return resolvedString(base, link["href"])
+		}
+	}
+	return ""
 }
@@ -0,0 +1,87 @@
+<?xml version="1.0" encoding="UTF-8"?>
+<xsl:stylesheet version="1.0"
+	xmlns:xsl="http://www.w3.org/1999/XSL/Transform"
+	xmlns:sm="http://www.sitemaps.org/schemas/sitemap/0.9"
+	xmlns:image="http://www.google.com/schemas/sitemap-image/1.1"
+	xmlns:video="http://www.google.com/schemas/sitemap-video/1.1"
+	exclude-result-prefixes="sm image video">
+
+	<xsl:output method="html" encoding="UTF-8" indent="yes"/>
+
+	<xsl:template match="/">
+		<html>
+			<head>
+				<meta charset="utf-8"/>
+				<title>XML Sitemap</title>
+				<style>
+					body { font-family: sans-serif; margin: 2em; color: #222; }
+					table { border-collapse: collapse; width: 100%; }
+					th, td { text-align: left; padding: 0.4em 0.8em; border-bottom: 1px solid #ddd; }
+					th { cursor: pointer; background: #f4f4f4; user-select: none; }
+					th:after { content: " \2195"; color: #999; }
+					tr:hover td { background: #fafafa; }
+				</style>
+			</head>
+			<body>
+				<xsl:apply-templates select="sm:urlset|sm:sitemapindex"/>
+				<script>
+					document.querySelectorAll("th").forEach(function (th, column) {
+						th.addEventListener("click", function () {
+							var body = th.closest("table").tBodies[0];
+							var rows = Array.prototype.slice.call(body.rows);
+							var ascending = th.dataset.order !== "asc";
+							th.dataset.order = ascending ? "asc" : "desc";
+							rows.sort(function (a, b) {
+								var x = a.cells[column].textContent, y = b.cells[column].textContent;
+								var n = parseFloat(x) - parseFloat(y);
+								var order = isNaN(n) ? x.localeCompare(y) : n;
+								return ascending ? order : -order;
+							});
+							rows.forEach(function (row) { body.appendChild(row); });
+						});
+					});
+				</script>
+			</body>
+		</html>
+	</xsl:template>
+
+	<xsl:template match="sm:urlset">
+		<h1>XML Sitemap</h1>
+		<p><xsl:value-of select="count(sm:url)"/> URLs</p>
+		<table>
+			<thead>
+				<tr><th>URL</th><th>Last modified</th><th>Change frequency</th><th>Priority</th><th>Images</th><th>Videos</th></tr>
+			</thead>
+			<tbody>
+				<xsl:for-each select="sm:url">
+					<tr>
+						<td><a href="{sm:loc}"><xsl:value-of select="sm:loc"/></a></td>
+						<td><xsl:value-of select="sm:lastmod"/></td>
+						<td><xsl:value-of select="sm:changefreq"/></td>
+						<td><xsl:value-of select="sm:priority"/></td>
+						<td><xsl:value-of select="count(image:image)"/></td>
+						<td><xsl:value-of select="count(video:video)"/></td>
+					</tr>
+				</xsl:for-each>
+			</tbody>
+		</table>
+	</xsl:template>
+
+	<xsl:template match="sm:sitemapindex">
+		<h1>XML Sitemap Index</h1>
+		<p><xsl:value-of select="count(sm:sitemap)"/> sitemaps</p>
+		<table>
+			<thead>
+				<tr><th>Sitemap</th><th>Last modified</th></tr>
+			</thead>
+			<tbody>
+				<xsl:for-each select="sm:sitemap">
+					<tr>
+						<td><a href="{sm:loc}"><xsl:value-of select="sm:loc"/></a></td>
+						<td><xsl:value-of select="sm:lastmod"/></td>
+					</tr>
+				</xsl:for-each>
+			</tbody>
+		</table>
+	</xsl:template>
+</xsl:stylesheet>
@@ -2,10 +2,19 @@ package sitemap
 
 import (
 	"bytes"
+	"encoding/json"
+	"encoding/xml"
 	"errors"
+	"fmt"
+	"html/template"
 	"io/ioutil"
+	"math"
 	"net/http"
+	"net/http/httptest"
 	"net/url"
+	"os"
+	"reflect"
+	"strings"
 	"testing"
 	"time"
 )
@@ -86,13 +95,13 @@ func TestExtractData(t *testing.T) {
 		html     string
 		expected Page
 	}{
-		{"", "", "", Page{LastModified: &time.Time{}}},
-		{"example.com", "http://example.com", `<a href="/">Home</a>`, Page{LastModified: &time.Time{}, Links: []string{"http://example.com/"}}},
-		{"example.com", "http://example.com", `<a href="//example.com">Home</a>`, Page{LastModified: &time.Time{}, Links: []string{"//example.com"}}},
-		{"example.com", "http://www.example.com", `<a href="http://www.example.com/home">Home</a>`, Page{LastModified: &time.Time{}, Links: []string{"http://www.example.com/home"}}},
-		{"example.com", "http://www.example.com", `<a href="http://www.example.com/home.jpg">Home</a>`, Page{LastModified: &time.Time{}, Links: []string{}}},
-		{"example.com", "http://www.example.com", `<a href="mailto:http://www.example.com/home.jpg">Home</a>`, Page{LastModified: &time.Time{}, Links: []string{}}},
-		{"example.com", "http://www.example", `<head><base href="http://www.example.com/"></head><a href="home">Home</a>`, Page{LastModified: &time.Time{}, Links: []string{}}},
+		{"", "", "", Page{}},
+		{"example.com", "http://example.com", `<a href="/">Home</a>`, Page{Links: []string{"http://example.com/"}}},
+		{"example.com", "http://example.com", `<a href="//example.com">Home</a>`, Page{Links: []string{"//example.com"}}},
+		{"example.com", "http://www.example.com", `<a href="http://www.example.com/home">Home</a>`, Page{Links: []string{"http://www.example.com/home"}}},
+		{"example.com", "http://www.example.com", `<a href="http://www.example.com/home.jpg">Home</a>`, Page{Links: []string{}}},
+		{"example.com", "http://www.example.com", `<a href="mailto:http://www.example.com/home.jpg">Home</a>`, Page{Links: []string{}}},
+		{"example.com", "http://www.example", `<head><base href="http://www.example.com/"></head><a href="home">Home</a>`, Page{Links: []string{}}},
 	}
 
 	for _, test := range testTable {
@@ -111,8 +120,8 @@ func TestExtractData(t *testing.T) {
 			t.Errorf("Expected %v, got %v", test.expected.Priority, page.Priority)
 		}
 
-		if !page.LastModified.Equal(*test.expected.LastModified) {
-			t.Errorf("Expected %v, got %v", test.expected.LastModified, page.LastModified)
+		if page.LastModified != nil {
+			t.Errorf("Expected no lastmod without a date, got %v", page.LastModified)
 		}
 
 		if page.ChangeFrequency != test.expected.ChangeFrequency {
@@ -133,6 +142,27 @@ func TestExtractData(t *testing.T) {
 	}
 }
 
+func TestExtractData_ResponseInfo(t *testing.T) {
+	testUrl, _ := url.Parse("http://example.com")
+	mockResponse := http.Response{
+		StatusCode: http.StatusOK,
+		Header:     make(http.Header),
+		Request:    &http.Request{URL: testUrl},
+		Body:       ioutil.NopCloser(bytes.NewBufferString(`<a href="/about">About</a>`)),
+	}
+
+	page, err := extractData(&mockResponse, "http://example.com")
+	if err != nil {
+		t.Errorf("Expected no error, got %v", err)
+	}
+	if page.StatusCode != http.StatusOK {
+		t.Errorf("Expected status %d, got %d", http.StatusOK, page.StatusCode)
+	}
+	if page.Size != 26 {
+		t.Errorf("Expected size 26, got %d", page.Size)
+	}
+}
+
 func TestFrequency_String(t *testing.T) {
 	if Always.String() != "Always" {
 		t.Errorf("Expected 'Always', got '%s'", Always.String())
@@ -178,3 +208,1643 @@ func TestParsePage(t *testing.T) {
 		}
 	}
 }
+
+func TestParsePageIfModified(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("ETag", `"v1"`)
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Write([]byte(`<a href="/about">About</a>`))
+	}))
+	defer server.Close()
+
+	page, err := ParsePageIfModified(server.URL, Validators{})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if page.NotModified {
+		t.Errorf("Expected modified page on unconditional request")
+	}
+	if page.Validators.ETag != `"v1"` {
+		t.Errorf("Expected ETag %q, got %q", `"v1"`, page.Validators.ETag)
+	}
+
+	page, err = ParsePageIfModified(server.URL, page.Validators)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if !page.NotModified {
+		t.Errorf("Expected not modified page")
+	}
+	if page.StatusCode != http.StatusNotModified {
+		t.Errorf("Expected status %d, got %d", http.StatusNotModified, page.StatusCode)
+	}
+	if page.Location != server.URL {
+		t.Errorf("Expected location %s, got %s", server.URL, page.Location)
+	}
+}
+
+func TestNotModifiedPage_KeepsValidators(t *testing.T) {
+	resp := &http.Response{StatusCode: http.StatusNotModified, Header: make(http.Header)}
+	previous := Validators{ETag: `"v1"`, LastModified: "Tue, 15 Nov 1994 08:12:31 GMT"}
+
+	page := notModifiedPage(resp, "http://example.com", previous, time.Second)
+	if page.Validators != previous {
+		t.Errorf("Expected validators %v, got %v", previous, page.Validators)
+	}
+}
+
+func TestNormaliseContent(t *testing.T) {
+	testTable := []struct {
+		html     string
+		expected string
+	}{
+		{"", ""},
+		{"<p>Hello   <b>world</b></p>", "Hello world"},
+		{"<body><nav>Menu</nav><main><h1>Title</h1>\n<p>Text &amp; more</p></main></body>", "Title Text & more"},
+		{"<body><article>Story</article><footer>2024</footer></body>", "Story"},
+		{"<body>Hi<script>var t = Date.now();</script><!-- build 42 --></body>", "Hi"},
+	}
+
+	for _, test := range testTable {
+		if got := NormaliseContent(test.html); got != test.expected {
+			t.Errorf("Expected %q, got %q", test.expected, got)
+		}
+	}
+}
+
+func TestContentHash(t *testing.T) {
+	a := ContentHash(`<body><main><p>Same text</p></main><script>var nonce = 1;</script></body>`)
+	b := ContentHash(`<body><main>  <div>Same   text</div></main><script>var nonce = 2;</script></body>`)
+	c := ContentHash(`<body><main><p>Other text</p></main></body>`)
+
+	if a != b {
+		t.Errorf("Expected equal hashes for equivalent content, got %s and %s", a, b)
+	}
+	if a == c {
+		t.Errorf("Expected different hashes for different content")
+	}
+}
+
+func TestParseDate(t *testing.T) {
+	expected := time.Date(1994, 11, 6, 8, 49, 37, 0, time.UTC)
+
+	testTable := []struct {
+		value    string
+		expected time.Time
+	}{
+		{"Sun, 06 Nov 1994 08:49:37 GMT", expected},
+		{"Sunday, 06-Nov-94 08:49:37 GMT", expected},
+		{"Sun Nov  6 08:49:37 1994", expected},
+		{"1994-11-06T08:49:37Z", expected},
+		{"1994-11-06T09:49:37.123+01:00", expected},
+		{"1994-11-06T08:49Z", time.Date(1994, 11, 6, 8, 49, 0, 0, time.UTC)},
+		{"1994-11-06", time.Date(1994, 11, 6, 0, 0, 0, 0, time.UTC)},
+		{"1994-11", time.Date(1994, 11, 1, 0, 0, 0, 0, time.UTC)},
+	}
+
+	for _, test := range testTable {
+		date, err := ParseDate(test.value)
+		if err != nil {
+			t.Errorf("Expected no error for %q, got %v", test.value, err)
+		}
+		if !date.Equal(test.expected) {
+			t.Errorf("Expected %v for %q, got %v", test.expected, test.value, date)
+		}
+	}
+
+	if _, err := ParseDate("yesterday"); err == nil {
+		t.Errorf("Expected error for unparsable date")
+	}
+}
+
+func TestParseLastmodSources(t *testing.T) {
+	sources, err := ParseLastmodSources("meta, JSONLD,header")
+	if err != nil {
+		t.Errorf("Expected no error, got %v", err)
+	}
+	expected := []LastmodSource{LastmodMeta, LastmodJSONLD, LastmodHeader}
+	if len(sources) != len(expected) {
+		t.Fatalf("Expected %v, got %v", expected, sources)
+	}
+	for i := range expected {
+		if sources[i] != expected[i] {
+			t.Errorf("Expected %v, got %v", expected[i], sources[i])
+		}
+	}
+
+	if _, err := ParseLastmodSources("header,sitemap"); err == nil {
+		t.Errorf("Expected error for unknown source")
+	}
+}
+
+func TestParseAttributes(t *testing.T) {
+	attributes := parseAttributes(`<meta content='2024-05-01' PROPERTY="article:modified_time" data-x=plain title="a &amp; b">`)
+
+	if attributes["property"] != "article:modified_time" {
+		t.Errorf("Expected property attribute, got %v", attributes)
+	}
+	if attributes["content"] != "2024-05-01" {
+		t.Errorf("Expected content attribute, got %v", attributes)
+	}
+	if attributes["data-x"] != "plain" {
+		t.Errorf("Expected unquoted attribute, got %v", attributes)
+	}
+	if attributes["title"] != "a & b" {
+		t.Errorf("Expected unescaped attribute, got %v", attributes)
+	}
+}
+
+func TestLastmodFromSources(t *testing.T) {
+	header := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	meta := time.Date(2021, 2, 2, 0, 0, 0, 0, time.UTC)
+	jsonld := time.Date(2022, 3, 3, 0, 0, 0, 0, time.UTC)
+	timeTag := time.Date(2023, 4, 4, 0, 0, 0, 0, time.UTC)
+
+	resp := &http.Response{Header: make(http.Header)}
+	resp.Header.Set("Last-Modified", header.Format(http.TimeFormat))
+
+	page := `<head><meta content="2021-02-02" property="article:modified_time">
+<script type="application/ld+json">{"@context":"https://schema.org","@graph":[{"@type":"WebPage","dateModified":"2022-03-03"}]}</script></head>
+<body><time datetime="2019-01-01">old</time><time datetime="2023-04-04">new</time></body>`
+
+	testTable := []struct {
+		sources  []LastmodSource
+		expected time.Time
+	}{
+		{DefaultLastmodSources, header},
+		{[]LastmodSource{LastmodMeta, LastmodHeader}, meta},
+		{[]LastmodSource{LastmodJSONLD}, jsonld},
+		{[]LastmodSource{LastmodTime}, timeTag},
+		{[]LastmodSource{}, time.Time{}},
+	}
+
+	for _, test := range testTable {
+		if got := lastmodFromSources(test.sources, resp, page); !got.Equal(test.expected) {
+			t.Errorf("Expected %v for %v, got %v", test.expected, test.sources, got)
+		}
+	}
+
+	empty := &http.Response{Header: make(http.Header)}
+	if got := lastmodFromSources(DefaultLastmodSources, empty, `<meta name="last-modified" content="2021-02-02">`); !got.Equal(meta) {
+		t.Errorf("Expected fallback to meta %v, got %v", meta, got)
+	}
+
+	dated := &http.Response{Header: make(http.Header)}
+	dated.Header.Set("Date", time.Date(2023, 6, 1, 0, 0, 0, 0, time.UTC).Format(http.TimeFormat))
+	events := `<time datetime="2023-04-04">updated</time><time datetime="2023-09-01">next event</time><time datetime="2099-01-01">far future</time>`
+	if got := lastmodFromSources([]LastmodSource{LastmodTime}, dated, events); !got.Equal(timeTag) {
+		t.Errorf("Expected future <time> dates to be ignored, got %v", got)
+	}
+	if got := lastmodFromSources([]LastmodSource{LastmodTime}, empty, `<time datetime="2099-01-01">event</time>`); !got.IsZero() {
+		t.Errorf("Expected no lastmod from a future date, got %v", got)
+	}
+}
+
+func TestExtractImages(t *testing.T) {
+	base, _ := url.Parse("http://example.com/products/shoe")
+
+	html := `<img src="/img/a.png" srcset="/img/a-2x.png 2x, https://cdn.example.net/a-3x.png 3x">
+<img src="data:image/gif;base64,R0lGOD">
+<img src="https://other.com/tracker.gif">
+<img src="b.jpg">
+<picture><source srcset="/img/c.webp 1x, /img/c@2x.webp 2x" type="image/webp"><img src="/img/a.png"></picture>
+<video><source src="/movie.mp4"></video>`
+
+	testTable := []struct {
+		parser   Parser
+		expected []string
+	}{
+		{Parser{}, []string{"http://example.com/img/a.png", "http://example.com/img/a-2x.png", "http://example.com/products/b.jpg", "http://example.com/img/c.webp", "http://example.com/img/c@2x.webp"}},
+		{Parser{MaxImages: 2}, []string{"http://example.com/img/a.png", "http://example.com/img/a-2x.png"}},
+		{Parser{MaxImages: 3, ImageHosts: []string{"*.example.net"}}, []string{"http://example.com/img/a.png", "http://example.com/img/a-2x.png", "https://cdn.example.net/a-3x.png"}},
+	}
+
+	for _, test := range testTable {
+		images := test.parser.extractImages(html, base)
+		if len(images) != len(test.expected) {
+			t.Errorf("Expected %v, got %v", test.expected, images)
+			continue
+		}
+		for i, image := range images {
+			if image.Location != test.expected[i] {
+				t.Errorf("Expected %s, got %s", test.expected[i], image.Location)
+			}
+		}
+	}
+}
+
+func TestDocumentBase(t *testing.T) {
+	pageUrl, _ := url.Parse("http://example.com/a/b")
+
+	if got := documentBase(`<base href="/static/">`, pageUrl).String(); got != "http://example.com/static/" {
+		t.Errorf("Expected http://example.com/static/, got %s", got)
+	}
+	if got := documentBase(``, pageUrl).String(); got != "http://example.com/a/b" {
+		t.Errorf("Expected page url, got %s", got)
+	}
+}
+
+func TestURLSetStart(t *testing.T) {
+	expected := `<urlset xmlns="http://www.sitemaps.org/schemas/sitemap/0.9" xmlns:image="http://www.google.com/schemas/sitemap-image/1.1">`
+	if got := URLSetStart(ImageExtension); got != expected {
+		t.Errorf("Expected %s, got %s", expected, got)
+	}
+}
+
+func TestExtractVideos(t *testing.T) {
+	base, _ := url.Parse("http://example.com/media/")
+
+	html := `<html><head><title>Media &amp; more</title>
+<meta name="description" content="Our videos">
+<meta property="og:video" content="https://player.example.com/embed/1">
+<meta property="og:video:type" content="text/html">
+<meta property="og:image" content="/thumbs/1.jpg">
+<meta property="og:title" content="Launch">
+<meta property="video:duration" content="90">
+<script type="application/ld+json">{"@type":"VideoObject","name":"Tour","description":"Office tour","contentUrl":"/videos/tour.mp4","thumbnailUrl":"/thumbs/tour.jpg","duration":"PT1M33S","uploadDate":"2024-01-02"}</script>
+</head><body>
+<video poster="/thumbs/tour-poster.jpg"><source src="/videos/tour.mp4" type="video/mp4"></video>
+<video src="clip.mp4" title="No thumbnail"></video>
+</body></html>`
+
+	videos := new(Parser).extractVideos(html, base)
+	if len(videos) != 2 {
+		t.Fatalf("Expected 2 videos, got %v", videos)
+	}
+
+	tour := videos[0]
+	if tour.ContentLocation != "http://example.com/videos/tour.mp4" {
+		t.Errorf("Expected tour content location, got %s", tour.ContentLocation)
+	}
+	if tour.ThumbnailLocation != "http://example.com/thumbs/tour-poster.jpg" {
+		t.Errorf("Expected poster thumbnail, got %s", tour.ThumbnailLocation)
+	}
+	if tour.Title != "Tour" || tour.Description != "Office tour" {
+		t.Errorf("Expected JSON-LD title and description, got %q %q", tour.Title, tour.Description)
+	}
+	if tour.Duration != 93 {
+		t.Errorf("Expected duration 93, got %d", tour.Duration)
+	}
+	if tour.PublicationDate == nil || !tour.PublicationDate.Equal(time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("Expected publication date 2024-01-02, got %v", tour.PublicationDate)
+	}
+
+	launch := videos[1]
+	if launch.PlayerLocation != "https://player.example.com/embed/1" || launch.ContentLocation != "" {
+		t.Errorf("Expected og:video as player location, got %+v", launch)
+	}
+	if launch.Description != "Our videos" {
+		t.Errorf("Expected page description fallback, got %q", launch.Description)
+	}
+	if launch.Duration != 90 {
+		t.Errorf("Expected duration 90, got %d", launch.Duration)
+	}
+}
+
+func TestParseISODuration(t *testing.T) {
+	testTable := []struct {
+		value    string
+		expected int
+	}{
+		{"PT1M33S", 93},
+		{"PT2H", 7200},
+		{"P1DT1S", 86401},
+		{"PT0.5S", 0},
+		{"90", 0},
+		{"", 0},
+	}
+
+	for _, test := range testTable {
+		if got := parseISODuration(test.value); got != test.expected {
+			t.Errorf("Expected %d for %q, got %d", test.expected, test.value, got)
+		}
+	}
+}
+
+func TestPageTitle(t *testing.T) {
+	if got := PageTitle("<head><title>\n  A &amp; B  </title></head>"); got != "A & B" {
+		t.Errorf("Expected 'A & B', got %q", got)
+	}
+	if got := PageTitle("<p>none</p>"); got != "" {
+		t.Errorf("Expected empty title, got %q", got)
+	}
+}
+
+func TestPublicationDate(t *testing.T) {
+	expected := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+
+	testTable := []struct {
+		html  string
+		found bool
+	}{
+		{`<meta property="article:published_time" content="2024-05-01T10:00:00Z">`, true},
+		{`<script type="application/ld+json">{"@type":"NewsArticle","headline":"x","datePublished":"2024-05-01T12:00:00+02:00"}</script>`, true},
+		{`<time pubdate datetime="2024-05-01T10:00:00Z">May 1</time>`, true},
+		{`<time datetime="2024-05-01T10:00:00Z">May 1</time>`, false},
+		{``, false},
+	}
+
+	for _, test := range testTable {
+		date, found := PublicationDate(test.html)
+		if found != test.found {
+			t.Errorf("Expected found %v for %s, got %v", test.found, test.html, found)
+		}
+		if found && !date.Equal(expected) {
+			t.Errorf("Expected %v, got %v", expected, date)
+		}
+	}
+}
+
+func TestExtractAlternates(t *testing.T) {
+	base, _ := url.Parse("http://example.com/en/")
+	resp := &http.Response{Header: make(http.Header)}
+	resp.Header.Add("Link", `<http://example.com/fr/>; rel="alternate"; hreflang="fr", <http://example.com/style.css>; rel=stylesheet`)
+
+	html := `<link rel="alternate" hreflang="en" href="/en/">
+<link hreflang="DE" rel="alternate" href="http://example.com/de/">
+<link rel="alternate" type="application/rss+xml" href="/feed">
+<link rel="alternate" hreflang="x-default" href="/">`
+
+	expected := []Alternate{
+		{Rel: "alternate", Hreflang: "en", Href: "http://example.com/en/"},
+		{Rel: "alternate", Hreflang: "de", Href: "http://example.com/de/"},
+		{Rel: "alternate", Hreflang: "x-default", Href: "http://example.com/"},
+		{Rel: "alternate", Hreflang: "fr", Href: "http://example.com/fr/"},
+	}
+
+	alternates := extractAlternates(resp, html, base)
+	if len(alternates) != len(expected) {
+		t.Fatalf("Expected %v, got %v", expected, alternates)
+	}
+	for i := range expected {
+		if alternates[i] != expected[i] {
+			t.Errorf("Expected %v, got %v", expected[i], alternates[i])
+		}
+	}
+}
+
+func TestApplyHreflang(t *testing.T) {
+	en := Alternate{Rel: "alternate", Hreflang: "en", Href: "http://example.com/en/"}
+	de := Alternate{Rel: "alternate", Hreflang: "de", Href: "http://example.com/de/"}
+	fr := Alternate{Rel: "alternate", Hreflang: "fr", Href: "http://example.com/fr/"}
+	xDefault := Alternate{Rel: "alternate", Hreflang: "x-default", Href: "http://example.com/en/"}
+
+	pages := []Page{
+		{Location: "http://example.com/en/", Alternates: []Alternate{en, de, xDefault}},
+		{Location: "http://example.com/de/", Alternates: []Alternate{de, en}},
+		{Location: "http://example.com/fr/", Alternates: []Alternate{fr, en}},
+		{Location: "http://example.com/about"},
+	}
+
+	issues := ApplyHreflang(pages)
+
+	for _, page := range pages[:3] {
+		if len(page.Alternates) != 4 {
+			t.Errorf("Expected 4 cluster alternates for %s, got %v", page.Location, page.Alternates)
+		}
+	}
+	if pages[0].Alternates[0].Hreflang != "de" {
+		t.Errorf("Expected alternates sorted by hreflang, got %v", pages[0].Alternates)
+	}
+	if len(pages[3].Alternates) != 0 {
+		t.Errorf("Expected no alternates for unrelated page, got %v", pages[3].Alternates)
+	}
+
+	if len(issues) != 1 {
+		t.Fatalf("Expected 1 issue, got %v", issues)
+	}
+	if issues[0].Kind != IssueNonReciprocal || issues[0].URL != "http://example.com/fr/" {
+		t.Errorf("Expected non-reciprocal issue for /fr/, got %v", issues[0])
+	}
+}
+
+func TestApplyHreflang_MissingXDefault(t *testing.T) {
+	en := Alternate{Rel: "alternate", Hreflang: "en", Href: "http://example.com/en/"}
+	de := Alternate{Rel: "alternate", Hreflang: "de", Href: "http://example.com/de/"}
+	other := Alternate{Rel: "alternate", Hreflang: "de", Href: "http://example.com/de-alt/"}
+
+	pages := []Page{
+		{Location: "http://example.com/en/", Alternates: []Alternate{en, de}},
+		{Location: "http://example.com/de/", Alternates: []Alternate{de, en, other}},
+	}
+
+	kinds := make(map[string]int)
+	for _, issue := range ApplyHreflang(pages) {
+		kinds[issue.Kind]++
+	}
+
+	if kinds[IssueMissingXDefault] != 1 {
+		t.Errorf("Expected 1 missing x-default issue, got %v", kinds)
+	}
+	if kinds[IssueConflict] != 1 {
+		t.Errorf("Expected 1 conflict issue, got %v", kinds)
+	}
+}
+
+func TestXMLWriter(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewXMLWriter(&buf, ImageExtension)
+
+	w.Write(Page{Location: "http://example.com", Priority: 1, Images: []Image{{Location: "http://example.com/a.png"}}})
+	if err := w.Close(); err != nil {
+		t.Errorf("Expected no error, got %v", err)
+	}
+
+	expected := URLSetStart(ImageExtension) + "\n <url>\n   <loc>http://example.com</loc>\n   <priority>1</priority>\n   <image:image>\n     <image:loc>http://example.com/a.png</image:loc>\n   </image:image>\n </url>\n</urlset>"
+	if buf.String() != expected {
+		t.Errorf("Expected %q, got %q", expected, buf.String())
+	}
+}
+
+func TestXMLWriter_Empty(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewXMLWriter(&buf)
+	w.Close()
+
+	if expected := URLSetStart() + "\n\n</urlset>"; buf.String() != expected {
+		t.Errorf("Expected %q, got %q", expected, buf.String())
+	}
+}
+
+func TestTextWriter(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewTextWriter(&buf)
+
+	w.Write(Page{Location: "http://example.com"})
+	w.Write(Page{Location: "http://example.com/about"})
+	w.Close()
+
+	if expected := "http://example.com\nhttp://example.com/about\n"; buf.String() != expected {
+		t.Errorf("Expected %q, got %q", expected, buf.String())
+	}
+}
+
+func TestJSONLWriter(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewJSONLWriter(&buf)
+	lastmod := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+
+	w.Write(Page{Location: "http://example.com", StatusCode: 200, Depth: 1, LastModified: &lastmod, Priority: 1, ChangeFrequency: Weekly, Links: []string{"http://example.com/a"}, FetchDuration: 1500 * time.Microsecond})
+	w.Write(Page{Location: "http://example.com/a", StatusCode: 200, Depth: 2, LastModified: &time.Time{}})
+	w.Close()
+
+	expected := `{"loc":"http://example.com","status":200,"depth":1,"lastmod":"2024-05-01T10:00:00Z","priority":1,"changefreq":"weekly","links":["http://example.com/a"],"fetch_ms":1.5}` + "\n" +
+		`{"loc":"http://example.com/a","status":200,"depth":2,"links":[],"fetch_ms":0}` + "\n"
+	if buf.String() != expected {
+		t.Errorf("Expected %q, got %q", expected, buf.String())
+	}
+}
+
+func TestCSVWriter(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewCSVWriter(&buf, CSVColumns)
+
+	w.Write(Page{
+		Location:      "http://example.com/a/",
+		Redirects:     []Hop{{URL: "http://example.com/a", Status: 301}},
+		StatusCode:    200,
+		ContentType:   "text/html",
+		Depth:         2,
+		Title:         "Hello, world",
+		Description:   "Déjà vu",
+		Canonical:     "http://example.com/a/",
+		InboundLinks:  3,
+		FetchDuration: 120 * time.Millisecond,
+		Inclusion:     Inclusion{Included: true, Reason: "included"},
+	})
+	w.Close()
+
+	expected := "url,final_url,status,content_type,depth,title,description_length,canonical,inbound_links,response_time_ms,included,reason\n" +
+		"http://example.com/a,http://example.com/a/,200,text/html,2,\"Hello, world\",7,http://example.com/a/,3,120,true,included\n"
+	if buf.String() != expected {
+		t.Errorf("Expected %q, got %q", expected, buf.String())
+	}
+}
+
+func TestParseCSVColumns(t *testing.T) {
+	columns, err := ParseCSVColumns(" URL, status,,reason ")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if strings.Join(columns, ",") != "url,status,reason" {
+		t.Errorf("Expected [url status reason], got %v", columns)
+	}
+
+	if _, err := ParseCSVColumns("url,colour"); err == nil {
+		t.Errorf("Expected error for unknown column")
+	}
+	if _, err := ParseCSVColumns(" , "); err == nil {
+		t.Errorf("Expected error for empty column list")
+	}
+}
+
+func TestCountInboundLinks(t *testing.T) {
+	pages := []Page{
+		{Location: "http://example.com", Links: []string{"http://example.com/a", "http://example.com/a", "http://example.com/b"}},
+		{Location: "http://example.com/a", Links: []string{"http://example.com/a", "http://example.com/b"}},
+		{Location: "http://example.com/b"},
+	}
+
+	CountInboundLinks(pages)
+
+	for i, expected := range []int{0, 1, 2} {
+		if pages[i].InboundLinks != expected {
+			t.Errorf("Expected %d inbound links for %s, got %d", expected, pages[i].Location, pages[i].InboundLinks)
+		}
+	}
+}
+
+func TestCanonicalURL(t *testing.T) {
+	base, _ := url.Parse("http://example.com/blog/post")
+	html := `<link rel="alternate" href="/fr"><link rel="canonical" href="/blog/post?x=1#top">`
+
+	if canonical := canonicalURL(html, base); canonical != "http://example.com/blog/post?x=1" {
+		t.Errorf("Expected resolved canonical, got %q", canonical)
+	}
+}
+
+func TestBuildHTMLSitemap(t *testing.T) {
+	data := BuildHTMLSitemap([]Page{
+		{Location: "http://example.com/", Title: "Home"},
+		{Location: "http://example.com/blog/post-2", Title: "Second"},
+		{Location: "http://example.com/blog/post-1"},
+		{Location: "http://example.com/about", Title: "About"},
+	})
+
+	if data.Title != "example.com" || data.Pages != 4 {
+		t.Fatalf("Expected title example.com with 4 pages, got %q with %d", data.Title, data.Pages)
+	}
+	if data.Root.Text != "Home" || len(data.Root.Children) != 2 {
+		t.Fatalf("Expected home page with 2 sections, got %+v", data.Root)
+	}
+
+	about, blog := data.Root.Children[0], data.Root.Children[1]
+	if about.Name != "about" || about.Text != "About" {
+		t.Errorf("Expected about page first, got %+v", about)
+	}
+	if blog.Name != "blog" || blog.Location != "" || len(blog.Children) != 2 {
+		t.Fatalf("Expected uncrawled blog section with 2 posts, got %+v", blog)
+	}
+	if post := blog.Children[0]; post.Name != "post-1" || post.Text != "http://example.com/blog/post-1" {
+		t.Errorf("Expected post-1 titled by its URL, got %+v", post)
+	}
+}
+
+func TestHTMLWriter(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewHTMLWriter(&buf, nil)
+
+	w.Write(Page{Location: "http://example.com/", Title: "Home"})
+	w.Write(Page{Location: "http://example.com/a?b=1&c=2", Title: "<A & B>"})
+	if err := w.Close(); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	for _, expected := range []string{
+		`<a href="http://example.com/">Home</a>`,
+		`<a href="http://example.com/a?b=1&amp;c=2">&lt;A &amp; B&gt;</a>`,
+	} {
+		if !strings.Contains(buf.String(), expected) {
+			t.Errorf("Expected output to contain %q, got %q", expected, buf.String())
+		}
+	}
+}
+
+func TestHTMLWriter_CustomTemplate(t *testing.T) {
+	var buf bytes.Buffer
+	tmpl := template.Must(template.New("custom").Parse(`{{.Title}}:{{.Pages}}{{range .Root.Children}} {{.Name}}={{.Text}}{{end}}`))
+	w := NewHTMLWriter(&buf, tmpl)
+
+	w.Write(Page{Location: "http://example.com/a", Title: "A"})
+	w.Close()
+
+	if expected := "example.com:1 a=A"; buf.String() != expected {
+		t.Errorf("Expected %q, got %q", expected, buf.String())
+	}
+}
+
+func TestBuildGraph(t *testing.T) {
+	pages := []Page{
+		{Location: "http://example.com", Links: []string{"http://example.com/a", "http://example.com/a", "http://example.com/b", "http://example.com/uncrawled"}},
+		{Location: "http://example.com/a", Links: []string{"http://example.com"}},
+		{Location: "http://example.com/b"},
+	}
+
+	graph := BuildGraph(pages, false)
+	if len(graph.Nodes) != 3 || len(graph.Edges) != 4 {
+		t.Errorf("Expected 3 nodes and 4 edges, got %d and %d", len(graph.Nodes), len(graph.Edges))
+	}
+
+	graph = BuildGraph(pages, true)
+	if len(graph.Edges) != 3 {
+		t.Fatalf("Expected 3 deduplicated edges, got %d", len(graph.Edges))
+	}
+	if edge := graph.Edges[0]; edge.To != "http://example.com/a" || edge.Weight != 2 {
+		t.Errorf("Expected edge to /a with weight 2, got %+v", edge)
+	}
+}
+
+func TestGraph_WriteGraphML(t *testing.T) {
+	var buf bytes.Buffer
+	graph := Graph{
+		Nodes: []GraphNode{{URL: "http://example.com/?a=1&b=2", Depth: 1, Status: 200}},
+		Edges: []GraphEdge{{From: "http://example.com/?a=1&b=2", To: "http://example.com/?a=1&b=2", Weight: 1}},
+	}
+
+	if err := graph.WriteGraphML(&buf); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	var doc struct {
+		Graph struct {
+			Nodes []struct {
+				ID string `xml:"id,attr"`
+			} `xml:"node"`
+			Edges []struct {
+				Source string `xml:"source,attr"`
+			} `xml:"edge"`
+		} `xml:"graph"`
+	}
+	if err := xml.Unmarshal(buf.Bytes(), &doc); err != nil {
+		t.Fatalf("Expected well-formed GraphML, got %v", err)
+	}
+	if len(doc.Graph.Nodes) != 1 || doc.Graph.Nodes[0].ID != "http://example.com/?a=1&b=2" || len(doc.Graph.Edges) != 1 {
+		t.Errorf("Expected one node and one edge, got %+v", doc.Graph)
+	}
+	if strings.Contains(buf.String(), "weight") {
+		t.Errorf("Expected no weight key for an unweighted graph")
+	}
+}
+
+func TestXMLWriter_Stylesheet(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewXMLWriter(&buf)
+	w.Stylesheet = "/sitemap.xsl?v=1&lang=en"
+	w.Close()
+
+	expected := `<?xml-stylesheet type="text/xsl" href="/sitemap.xsl?v=1&amp;lang=en"?>` + "\n" + URLSetStart() + "\n\n</urlset>"
+	if buf.String() != expected {
+		t.Errorf("Expected %q, got %q", expected, buf.String())
+	}
+}
+
+func TestDefaultStylesheet(t *testing.T) {
+	var doc struct {
+		XMLName   xml.Name
+		Templates []struct {
+			Match string `xml:"match,attr"`
+		} `xml:"template"`
+	}
+	if err := xml.Unmarshal(DefaultStylesheet, &doc); err != nil {
+		t.Fatalf("Expected well-formed stylesheet, got %v", err)
+	}
+
+	matches := make(map[string]bool)
+	for _, tmpl := range doc.Templates {
+		matches[tmpl.Match] = true
+	}
+	if doc.XMLName.Local != "stylesheet" || !matches["sm:urlset"] || !matches["sm:sitemapindex"] {
+		t.Errorf("Expected templates for urlset and sitemapindex, got %+v", doc)
+	}
+}
+
+type recordingWriter struct {
+	locations []string
+	closed    bool
+}
+
+func (rw *recordingWriter) Write(page Page) error {
+	rw.locations = append(rw.locations, page.Location)
+	return nil
+}
+
+func (rw *recordingWriter) Close() error {
+	rw.closed = true
+	return nil
+}
+
+func TestSortedWriter(t *testing.T) {
+	pages := []Page{
+		{Location: "http://example.com/c", Priority: 0.5, Depth: 2},
+		{Location: "http://example.com", Priority: 1, Depth: 1},
+		{Location: "http://example.com/b", Priority: 0.5, Depth: 3},
+		{Location: "http://example.com/a", Priority: 0.8, Depth: 2},
+		{Location: "http://example.com/a/x", Priority: 0.5, Depth: 3},
+	}
+
+	testData := []struct {
+		order    SortOrder
+		budget   int
+		expected string
+	}{
+		{SortLoc, DefaultSortMemory, "http://example.com http://example.com/a http://example.com/a/x http://example.com/b http://example.com/c"},
+		{SortPriority, DefaultSortMemory, "http://example.com http://example.com/a http://example.com/a/x http://example.com/b http://example.com/c"},
+		{SortDepth, DefaultSortMemory, "http://example.com http://example.com/a http://example.com/c http://example.com/a/x http://example.com/b"},
+		{SortLoc, 1, "http://example.com http://example.com/a http://example.com/a/x http://example.com/b http://example.com/c"},
+		{SortDepth, 300, "http://example.com http://example.com/a http://example.com/c http://example.com/a/x http://example.com/b"},
+	}
+
+	for _, test := range testData {
+		rw := &recordingWriter{}
+		sw := NewSortedWriter(rw, test.order, test.budget)
+		for _, page := range pages {
+			if err := sw.Write(page); err != nil {
+				t.Fatalf("Expected no error, got %v", err)
+			}
+		}
+
+		spill := sw.spill
+		if err := sw.Close(); err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+
+		if got := strings.Join(rw.locations, " "); got != test.expected {
+			t.Errorf("Expected %s order with budget %d to be %q, got %q", test.order, test.budget, test.expected, got)
+		}
+		if !rw.closed {
+			t.Errorf("Expected underlying writer to be closed")
+		}
+		if spill != nil {
+			if _, err := os.Stat(spill.Name()); !os.IsNotExist(err) {
+				t.Errorf("Expected temp file %s to be removed", spill.Name())
+			}
+		}
+	}
+}
+
+func TestSortedWriter_Stable(t *testing.T) {
+	for _, budget := range []int{DefaultSortMemory, 1} {
+		var buf bytes.Buffer
+		sw := NewSortedWriter(NewJSONLWriter(&buf), SortLoc, budget)
+		for _, priority := range []float64{0.3, 0.1, 0.2} {
+			sw.Write(Page{Location: "http://example.com/a", Priority: priority})
+		}
+		sw.Write(Page{Location: "http://example.com", Priority: 0.9})
+		sw.Close()
+
+		var priorities []string
+		for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+			var record struct{ Priority float64 }
+			json.Unmarshal([]byte(line), &record)
+			priorities = append(priorities, fmt.Sprint(record.Priority))
+		}
+		if got := strings.Join(priorities, " "); got != "0.9 0.3 0.1 0.2" {
+			t.Errorf("Expected equal pages in written order with budget %d, got %s", budget, got)
+		}
+	}
+}
+
+func TestSortedWriter_SpillKeepsPageData(t *testing.T) {
+	var buf bytes.Buffer
+	lastmod := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	sw := NewSortedWriter(NewXMLWriter(&buf, ImageExtension), SortLoc, 1)
+
+	sw.Write(Page{Location: "http://example.com/b", LastModified: &lastmod, ChangeFrequency: Weekly, Images: []Image{{Location: "http://example.com/b.png"}}})
+	sw.Write(Page{Location: "http://example.com/a", Priority: 0.5})
+	if len(sw.runs) != 2 {
+		t.Fatalf("Expected 2 spilled runs, got %d", len(sw.runs))
+	}
+	sw.Close()
+
+	var expected bytes.Buffer
+	xw := NewXMLWriter(&expected, ImageExtension)
+	xw.Write(Page{Location: "http://example.com/a", Priority: 0.5})
+	xw.Write(Page{Location: "http://example.com/b", LastModified: &lastmod, ChangeFrequency: Weekly, Images: []Image{{Location: "http://example.com/b.png"}}})
+	xw.Close()
+
+	if buf.String() != expected.String() {
+		t.Errorf("Expected %q, got %q", expected.String(), buf.String())
+	}
+}
+
+func TestParseSortOrder(t *testing.T) {
+	for _, order := range []string{"", "loc", "priority", "depth"} {
+		if _, err := ParseSortOrder(order); err != nil {
+			t.Errorf("Expected %q to be valid, got %v", order, err)
+		}
+	}
+	if _, err := ParseSortOrder("size"); err == nil {
+		t.Errorf("Expected error for unknown sort order")
+	}
+}
+
+func TestPattern_Match(t *testing.T) {
+	testData := []struct {
+		pattern  string
+		url      string
+		expected bool
+	}{
+		{"/admin*", "http://example.com/admin", true},
+		{"/admin*", "http://example.com/admin/users?page=2", true},
+		{"/admin*", "http://example.com/blog/admin", false},
+		{"/search?q=*", "http://example.com/search?q=shoes", true},
+		{"/search?q=*", "http://example.com/searches", false},
+		{"/", "http://example.com", true},
+		{"/shop/*/*", "http://example.com/shop/shoes/red", true},
+		{"re:[?&](color|size)=", "http://example.com/shop?size=42&page=1", true},
+		{"re:[?&](color|size)=", "http://example.com/shop?page=1", false},
+		{"re:^/cart$", "http://example.com/cart", true},
+		{"re:^/cart$", "http://example.com/cart/items", false},
+	}
+
+	for _, test := range testData {
+		pattern, err := ParsePattern(test.pattern)
+		if err != nil {
+			t.Fatalf("Expected no error for %q, got %v", test.pattern, err)
+		}
+
+		u, _ := url.Parse(test.url)
+		if matched := pattern.Match(u); matched != test.expected {
+			t.Errorf("Expected %q matching %s to be %v, got %v", test.pattern, test.url, test.expected, matched)
+		}
+	}
+
+	if _, err := ParsePattern("re:("); err == nil {
+		t.Errorf("Expected error for invalid regex")
+	}
+}
+
+func TestScopeRules_Decide(t *testing.T) {
+	mustPattern := func(raw string) *Pattern {
+		pattern, err := ParsePattern(raw)
+		if err != nil {
+			t.Fatalf("Expected no error for %q, got %v", raw, err)
+		}
+		return pattern
+	}
+
+	rules := ScopeRules{
+		Include: []*Pattern{mustPattern("/"), mustPattern("/shop*"), mustPattern("/search*")},
+		Exclude: []*Pattern{mustPattern("/shop/cart*")},
+		NoList:  []*Pattern{mustPattern("/search*"), mustPattern("re:[?&]color=")},
+	}
+
+	testData := []struct {
+		url      string
+		expected ScopeDecision
+	}{
+		{"http://example.com/", ScopeList},
+		{"http://example.com/shop/shoes", ScopeList},
+		{"http://example.com/shop/shoes?color=red", ScopeCrawlOnly},
+		{"http://example.com/search?q=shoes", ScopeCrawlOnly},
+		{"http://example.com/shop/cart", ScopeSkip},
+		{"http://example.com/about", ScopeSkip},
+	}
+
+	for _, test := range testData {
+		u, _ := url.Parse(test.url)
+		if decision := rules.Decide(u); decision != test.expected {
+			t.Errorf("Expected %s for %s, got %s", test.expected, test.url, decision)
+		}
+	}
+
+	if decision := (ScopeRules{}).Decide(&url.URL{Path: "/anything"}); decision != ScopeList {
+		t.Errorf("Expected empty rules to list everything, got %s", decision)
+	}
+}
+
+func TestParser_Scope(t *testing.T) {
+	testUrl, _ := url.Parse("http://example.com")
+	mockResponse := http.Response{
+		StatusCode: http.StatusOK,
+		Header:     make(http.Header),
+		Request:    &http.Request{URL: testUrl},
+		Body:       ioutil.NopCloser(bytes.NewBufferString(`<a href="/about">About</a><a href="/admin/users">Admin</a><a href="/search?q=x">Search</a>`)),
+	}
+
+	parser := &Parser{Scope: func(u *url.URL) ScopeDecision {
+		switch {
+		case strings.HasPrefix(u.Path, "/admin"):
+			return ScopeSkip
+		case u.Path == "/search":
+			return ScopeCrawlOnly
+		}
+		return ScopeList
+	}}
+
+	page, err := parser.extractData(&mockResponse, "http://example.com")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if strings.Join(page.Links, " ") != "http://example.com/about http://example.com/search?q=x" {
+		t.Errorf("Expected skipped links to be dropped, got %v", page.Links)
+	}
+
+	if decision := parser.Decide("http://example.com/search"); decision != ScopeCrawlOnly {
+		t.Errorf("Expected crawl-only, got %s", decision)
+	}
+	if decision := (*Parser)(nil).Decide("http://example.com/admin"); decision != ScopeList {
+		t.Errorf("Expected nil parser to list everything, got %s", decision)
+	}
+}
+
+func TestHostScope_Allows(t *testing.T) {
+	testData := []struct {
+		scope    HostScope
+		host     string
+		expected bool
+	}{
+		{HostScope{Policy: HostExact, Start: "example.com"}, "example.com", true},
+		{HostScope{Policy: HostExact, Start: "example.com"}, "www.example.com", false},
+		{HostScope{Policy: HostWWW, Start: "example.com"}, "www.example.com", true},
+		{HostScope{Policy: HostWWW, Start: "www.example.com"}, "example.com", true},
+		{HostScope{Policy: HostWWW, Start: "example.com"}, "blog.example.com", false},
+		{HostScope{Policy: HostDomain, Start: "www.example.com"}, "blog.example.com", true},
+		{HostScope{Policy: HostDomain, Start: "www.example.com"}, "example.com", true},
+		{HostScope{Policy: HostDomain, Start: "www.example.com"}, "badexample.com", false},
+		{HostScope{Policy: HostDomain, Start: "shop.example.co.uk"}, "blog.example.co.uk", true},
+		{HostScope{Policy: HostDomain, Start: "shop.example.co.uk"}, "other.co.uk", false},
+		{HostScope{Policy: HostDomain, Start: "a.b.example.com", Domain: "b.example.com"}, "a.example.com", false},
+		{HostScope{Policy: HostAllowlist, Start: "example.com", Hosts: []string{"docs.example.org"}}, "DOCS.example.org", true},
+		{HostScope{Policy: HostAllowlist, Start: "example.com", Hosts: []string{"docs.example.org"}}, "www.example.com", false},
+	}
+
+	for _, test := range testData {
+		if allowed := test.scope.Allows(test.host); allowed != test.expected {
+			t.Errorf("Expected %s policy from %s to allow %s: %v, got %v", test.scope.Policy, test.scope.Start, test.host, test.expected, allowed)
+		}
+	}
+}
+
+func TestRegistrableDomain(t *testing.T) {
+	testData := map[string]string{
+		"example.com":            "example.com",
+		"www.blog.example.com":   "example.com",
+		"shop.example.co.uk":     "example.co.uk",
+		"www.example.com.au":     "example.com.au",
+		"www.example.io":         "example.io",
+		"127.0.0.1":              "127.0.0.1",
+		"localhost":              "localhost",
+		"news.example.community": "example.community",
+	}
+
+	for host, expected := range testData {
+		if domain := RegistrableDomain(host); domain != expected {
+			t.Errorf("Expected %s for %s, got %s", expected, host, domain)
+		}
+	}
+}
+
+func TestParser_Hosts(t *testing.T) {
+	pageUrl, _ := url.Parse("http://www.example.com/")
+	html := `<a href="/about">About</a><a href="http://example.com/bare">Bare</a><a href="http://blog.example.com/">Blog</a><a href="http://other.com/">Other</a>`
+	parser := &Parser{Hosts: &HostScope{Policy: HostDomain, Start: "www.example.com"}}
+
+	mockResponse := http.Response{
+		StatusCode: http.StatusOK,
+		Header:     make(http.Header),
+		Request:    &http.Request{URL: pageUrl},
+		Body:       ioutil.NopCloser(bytes.NewBufferString(html)),
+	}
+	page, err := parser.extractData(&mockResponse, "http://www.example.com/")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if strings.Join(page.Links, " ") != "http://www.example.com/about http://example.com/bare http://blog.example.com/" {
+		t.Errorf("Expected links within the domain, got %v", page.Links)
+	}
+}
+
+func TestWriteSitemapIndex(t *testing.T) {
+	var buf bytes.Buffer
+	lastmod := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	err := WriteSitemapIndex(&buf, "/sitemap.xsl", []IndexEntry{
+		{Location: "http://example.com/sitemap-example.com.xml", LastModified: &lastmod},
+		{Location: "http://example.com/sitemap-blog.example.com.xml"},
+	})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	expected := StylesheetPI("/sitemap.xsl") + "\n" +
+		`<sitemapindex xmlns="http://www.sitemaps.org/schemas/sitemap/0.9">` + "\n" +
+		" <sitemap>\n   <loc>http://example.com/sitemap-example.com.xml</loc>\n   <lastmod>2024-05-01T10:00:00Z</lastmod>\n </sitemap>" +
+		" <sitemap>\n   <loc>http://example.com/sitemap-blog.example.com.xml</loc>\n </sitemap>" +
+		"\n</sitemapindex>"
+	if buf.String() != expected {
+		t.Errorf("Expected %q, got %q", expected, buf.String())
+	}
+}
+
+func TestLinkFilter_Allows(t *testing.T) {
+	testData := []struct {
+		link      string
+		documents bool
+		expected  bool
+	}{
+		{"http://example.com/page", false, true},
+		{"https://example.com/blog/", false, true},
+		{"http://example.com/clip.mp4", false, false},
+		{"http://example.com/plan.DWG", false, false},
+		{"http://example.com/song.aac", false, false},
+		{"http://example.com/backup.tar.gz", false, false},
+		{"http://example.com/download.zip?version=2", false, false},
+		{"http://example.com/view?file=report.zip", false, true},
+		{"http://example.com/images.jpg.html", false, true},
+		{"http://example.com/tips/sms:howto", false, true},
+		{"http://example.com/report.pdf", false, false},
+		{"http://example.com/report.pdf", true, true},
+		{"http://example.com/sheet.xlsx", true, true},
+		{"http://example.com/logo.png", true, false},
+		{"ftp://example.com/page", false, false},
+		{"mailto:info@example.com", false, false},
+		{"javascript:void(0)", false, false},
+		{"/relative/page", false, true},
+	}
+
+	for _, test := range testData {
+		u, err := url.Parse(test.link)
+		if err != nil {
+			t.Fatalf("Expected no error for %s, got %v", test.link, err)
+		}
+		if allowed := NewLinkFilter(test.documents).Allows(u); allowed != test.expected {
+			t.Errorf("Expected %s with documents=%v allowed: %v, got %v", test.link, test.documents, test.expected, allowed)
+		}
+	}
+}
+
+func TestLinkFilter_SkipAllow(t *testing.T) {
+	filter := NewLinkFilter(false)
+	filter.Skip("PHP", ".asp")
+	filter.Allow(".pdf")
+
+	for link, expected := range map[string]bool{
+		"http://example.com/index.php":  false,
+		"http://example.com/page.asp":   false,
+		"http://example.com/report.pdf": true,
+		"http://example.com/notes.txt":  false,
+	} {
+		u, _ := url.Parse(link)
+		if allowed := filter.Allows(u); allowed != expected {
+			t.Errorf("Expected %s allowed: %v, got %v", link, expected, allowed)
+		}
+	}
+}
+
+func TestExtractLinks_Schemes(t *testing.T) {
+	pageUrl, _ := url.Parse("http://example.com/")
+	html := `<base href="http://example.com/docs/">` +
+		`<a href="mailto:info@example.com">Mail</a>` +
+		`<a href="tel:+123">Call</a>` +
+		`<a href="javascript:void(0)">JS</a>` +
+		`<a href="/tips/sms:howto">SMS tips</a>` +
+		`<a href="guide.html">Guide</a>` +
+		`<a href="/files/manual.pdf">Manual</a>`
+
+	links := extractLinks(html, pageUrl)
+	if strings.Join(links, " ") != "http://example.com/tips/sms:howto http://example.com/docs/guide.html" {
+		t.Errorf("Expected only http links without skipped extensions, got %v", links)
+	}
+
+	links = extractScopedLinks(html, pageUrl, nil, NewLinkFilter(true))
+	if len(links) != 3 || links[2] != "http://example.com/files/manual.pdf" {
+		t.Errorf("Expected the PDF with documents enabled, got %v", links)
+	}
+}
+
+func TestTrapDetector(t *testing.T) {
+	d := NewTrapDetector(TrapLimits{MaxRepeats: 2, MaxPathDepth: 4, MaxURLLength: 60, MaxQueryVariants: 3})
+
+	testData := []struct {
+		url      string
+		expected bool
+	}{
+		{"http://example.com/a/b/a/b", false},
+		{"http://example.com/a/b/a/b/a", true},
+		{"http://example.com/a/b/a/b/a/b", true},
+		{"http://example.com/1/2/3/4", false},
+		{"http://example.com/1/2/3/4/5", true},
+		{"http://example.com/search?q=" + strings.Repeat("x", 40), true},
+		{"http://example.com/calendar?month=1", false},
+		{"http://example.com/calendar?month=2", false},
+		{"http://example.com/calendar?month=3", false},
+		{"http://example.com/calendar?month=4", true},
+		{"http://example.com/calendar?month=5", true},
+		{"http://example.com/calendar?month=1&year=2024", false},
+		{"http://example.com/other?month=6", false},
+	}
+
+	for _, test := range testData {
+		u, _ := url.Parse(test.url)
+		if trapped := d.Check(u); trapped != test.expected {
+			t.Errorf("Expected %s trapped: %v, got %v", test.url, test.expected, trapped)
+		}
+	}
+
+	var reported []string
+	for _, trap := range d.Traps() {
+		reported = append(reported, trap.String())
+	}
+	expected := []string{
+		"repeating-segments: http://example.com/a/… (2 URLs cut off)",
+		"query-variants: http://example.com/calendar?month=* (2 URLs cut off)",
+		"path-depth: http://example.com/1/2/3/4/… (1 URLs cut off)",
+		"url-length: http://example.com/search?q=* (1 URLs cut off)",
+	}
+	if strings.Join(reported, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("Expected traps %q, got %q", expected, reported)
+	}
+}
+
+func TestTrapDetector_Disabled(t *testing.T) {
+	d := NewTrapDetector(TrapLimits{})
+	u, _ := url.Parse("http://example.com/a/a/a/a/a/a/a/a/a/a/a/a/a/a?page=1")
+	if d.Check(u) || len(d.Traps()) != 0 {
+		t.Errorf("Expected no traps with all limits disabled")
+	}
+}
+
+func TestQueryTemplate(t *testing.T) {
+	u, _ := url.Parse("https://example.com/shop/shoes?size=42&color=red&color=blue#reviews")
+	if template := QueryTemplate(u); template != "https://example.com/shop/shoes?color=*&size=*" {
+		t.Errorf("Expected sorted parameters with values replaced, got %s", template)
+	}
+}
+
+func TestDepthPriority(t *testing.T) {
+	pages := []Page{{Depth: 1}, {Depth: 3}, {Depth: 9}, {Depth: 12}}
+
+	DepthPriority{Floor: 0.1}.Assign(pages)
+	for i, expected := range []float64{1, 0.8, 0.2, 0.1} {
+		if pages[i].Priority != expected {
+			t.Errorf("Expected priority %v at depth %d, got %v", expected, pages[i].Depth, pages[i].Priority)
+		}
+	}
+
+	DepthPriority{Table: map[int]float64{1: 1, 2: 0.5, 3: 0.05}, Floor: 0.2}.Assign(pages)
+	for i, expected := range []float64{1, 0.2, 0.2, 0.2} {
+		if pages[i].Priority != expected {
+			t.Errorf("Expected priority %v at depth %d with custom table, got %v", expected, pages[i].Depth, pages[i].Priority)
+		}
+	}
+}
+
+func TestParsePriorityTable(t *testing.T) {
+	table, err := ParsePriorityTable(" 1:1.0, 2:0.8,,3 : 0.5 ")
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if len(table) != 3 || table[1] != 1 || table[2] != 0.8 || table[3] != 0.5 {
+		t.Errorf("Expected 3 entries, got %v", table)
+	}
+
+	for _, invalid := range []string{"1", "x:0.5", "0:0.5", "1:1.5", "1:high"} {
+		if _, err := ParsePriorityTable(invalid); err == nil {
+			t.Errorf("Expected error for %q", invalid)
+		}
+	}
+}
+
+func TestInboundLinkPriority(t *testing.T) {
+	pages := []Page{
+		{Location: "http://example.com", Links: []string{"http://example.com/a", "http://example.com/b"}},
+		{Location: "http://example.com/a", Links: []string{"http://example.com/b"}},
+		{Location: "http://example.com/b", Links: []string{"http://example.com/a"}},
+		{Location: "http://example.com/c", Links: []string{"http://example.com/b"}},
+	}
+
+	InboundLinkPriority{Floor: 0.1}.Assign(pages)
+	for i, expected := range []float64{0.1, 0.8, 1, 0.1} {
+		if pages[i].Priority != expected {
+			t.Errorf("Expected priority %v for %s, got %v", expected, pages[i].Location, pages[i].Priority)
+		}
+	}
+}
+
+func TestPageRank(t *testing.T) {
+	pages := []Page{
+		{Location: "http://example.com", Links: []string{"http://example.com/a", "http://example.com/b"}},
+		{Location: "http://example.com/a", Links: []string{"http://example.com"}},
+		{Location: "http://example.com/b", Links: []string{"http://example.com"}},
+		{Location: "http://example.com/dead-end"},
+	}
+
+	rank := PageRank(pages, 0, 0)
+
+	sum := 0.0
+	for _, r := range rank {
+		sum += r
+	}
+	if math.Abs(sum-1) > 1e-6 {
+		t.Errorf("Expected ranks to sum to 1, got %v", sum)
+	}
+	if !(rank[0] > rank[1] && math.Abs(rank[1]-rank[2]) < 1e-9 && rank[1] > rank[3]) {
+		t.Errorf("Expected home > a = b > dead end, got %v", rank)
+	}
+
+	PageRankPriority{Floor: 0.1}.Assign(pages)
+	if pages[0].Priority != 1 || pages[3].Priority != 0.1 {
+		t.Errorf("Expected home at 1.0 and the dead end at the floor, got %v and %v", pages[0].Priority, pages[3].Priority)
+	}
+}
+
+func TestParseRules(t *testing.T) {
+	data := []byte(`{
+  "rules": [
+    {"pattern": "/blog/archive/*", "exclude": true},
+    {"pattern": "/blog/*", "priority": 0.8, "changefreq": "Weekly"},
+
+    {"pattern": "re:^/news/", "lastmod": ["meta", "header"]}
+  ]
+}`)
+
+	rules, err := ParseRules("rules.json", data)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if len(rules) != 3 {
+		t.Fatalf("Expected 3 rules, got %d", len(rules))
+	}
+	if rules[0].Line != 3 || rules[1].Line != 4 || rules[2].Line != 6 {
+		t.Errorf("Expected rules on lines 3, 4 and 6, got %d, %d and %d", rules[0].Line, rules[1].Line, rules[2].Line)
+	}
+
+	if rule := rules.Match("http://example.com/blog/archive/2020"); rule == nil || !rule.Exclude || rule.Priority != nil {
+		t.Errorf("Expected the first matching rule to win, got %+v", rule)
+	}
+	if rule := rules.Match("http://example.com/blog/post"); rule == nil || *rule.Priority != 0.8 || rule.ChangeFreq != Weekly {
+		t.Errorf("Expected blog rule, got %+v", rule)
+	}
+	if rule := rules.Match("http://example.com/news/today"); rule == nil || len(rule.Lastmod) != 2 || rule.Lastmod[0] != LastmodMeta {
+		t.Errorf("Expected news lastmod rule, got %+v", rule)
+	}
+	if rule := rules.Match("http://example.com/about"); rule != nil {
+		t.Errorf("Expected no rule, got %+v", rule)
+	}
+}
+
+func TestParseRules_Errors(t *testing.T) {
+	testData := []struct {
+		data     string
+		expected string
+	}{
+		{"{\n  \"rules\": [\n    {\"pattern\": \"/a\", \"priority\": 0.5,}\n  ]\n}", "rules.json:3: invalid character '}'"},
+		{"[]", "rules.json:1: expected an object"},
+		{"{\n  \"rule\": []\n}", `rules.json:2: unknown key "rule"`},
+		{"{\"rules\": {}}", `rules.json:1: "rules" must be a list`},
+		{"{\"rules\": [\n  {\"pattern\": \"/a\", \"exclude\": true},\n  {\"pattern\": \"/b\",\n   \"priority\": \"high\"}\n]}", "rules.json:4: priority must be a float64"},
+		{"{\"rules\": [\n  {\"pattern\": \"/b\",\n   \"changefreq\": \"sometimes\"}\n]}", `rules.json:3: unknown changefreq "sometimes"`},
+		{"{\"rules\": [\n\n  {\"pattern\": \"/b\", \"priority\": 1.5}\n]}", "rules.json:3: priority 1.5 isn't between 0 and 1"},
+		{"{\"rules\": [\n  {\"pattern\": \"re:(\", \"exclude\": true}\n]}", `rules.json:2: invalid pattern "re:("`},
+		{"{\"rules\": [\n  {\"pattern\": \"/b\"}\n]}", `rules.json:2: rule for "/b" sets none of`},
+		{"{\"rules\": [\n  {\"exclude\": true}\n]}", "rules.json:2: rule without a pattern"},
+		{"{\"rules\": [\n  {\"pattern\": \"/b\",\n\n   \"weight\": 2}\n]}", `rules.json:4: unknown field "weight"`},
+		{"{\"rules\": [\n  {\"pattern\": \"/b\", \"lastmod\": [\"etag\"]}\n]}", `rules.json:2: unknown lastmod source "etag"`},
+	}
+
+	for _, test := range testData {
+		_, err := ParseRules("rules.json", []byte(test.data))
+		if err == nil || !strings.HasPrefix(err.Error(), test.expected) {
+			t.Errorf("Expected error starting with %q for %s, got %v", test.expected, test.data, err)
+		}
+	}
+}
+
+func TestRules_Apply(t *testing.T) {
+	rules, err := ParseRules("rules.json", []byte(`{"rules": [{"pattern": "/blog/*", "changefreq": "daily"}, {"pattern": "/live", "changefreq": "always"}, {"pattern": "/*", "priority": 0.3}]}`))
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	pages := []Page{
+		{Location: "http://example.com/blog/post", Priority: 0.8},
+		{Location: "http://example.com/about", Priority: 0.8},
+		{Location: "http://example.com/live", Priority: 0.8},
+	}
+	rules.Apply(pages)
+
+	if pages[0].Priority != 0.8 || pages[0].ChangeFrequency != Daily {
+		t.Errorf("Expected blog rule to only set changefreq, got %v %v", pages[0].Priority, pages[0].ChangeFrequency)
+	}
+	if pages[1].Priority != 0.3 || pages[1].ChangeFrequency != None {
+		t.Errorf("Expected catch-all rule to only set priority, got %v %v", pages[1].Priority, pages[1].ChangeFrequency)
+	}
+	if pages[2].ChangeFrequency != Always {
+		t.Errorf("Expected live rule to set changefreq always, got %v", pages[2].ChangeFrequency)
+	}
+}
+
+func TestParser_RulesLastmod(t *testing.T) {
+	rules, err := ParseRules("rules.json", []byte(`{"rules": [{"pattern": "/news/*", "lastmod": ["meta"]}]}`))
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	parser := &Parser{Rules: rules}
+
+	if sources := parser.lastmodSourcesFor("http://example.com/news/a"); len(sources) != 1 || sources[0] != LastmodMeta {
+		t.Errorf("Expected meta lastmod for news, got %v", sources)
+	}
+	if sources := parser.lastmodSourcesFor("http://example.com/about"); len(sources) != len(DefaultLastmodSources) {
+		t.Errorf("Expected default lastmod sources, got %v", sources)
+	}
+}
+
+func TestParser_NoLastmod(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Last-Modified", "Tue, 15 Nov 1994 08:12:31 GMT")
+		fmt.Fprint(w, `<html><body>No dates here</body></html>`)
+	}))
+	defer server.Close()
+
+	rules, err := ParseRules("rules.json", []byte(`{"rules": [{"pattern": "/news/*", "lastmod": ["meta"]}]}`))
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	parser := &Parser{Rules: rules}
+
+	var buf bytes.Buffer
+	w := NewXMLWriter(&buf)
+	for _, path := range []string{"/", "/news/a"} {
+		page, err := parser.Parse(server.URL+path, Validators{})
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		w.Write(page)
+	}
+	w.Close()
+
+	if strings.Count(buf.String(), "<lastmod>") != 1 {
+		t.Errorf("Expected a lastmod only for the page with a date from its sources, got %q", buf.String())
+	}
+	if strings.Contains(buf.String(), "0001-01-01") {
+		t.Errorf("Expected no zero lastmod, got %q", buf.String())
+	}
+}
+
+func TestFrequency_Text(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewXMLWriter(&buf)
+	w.Write(Page{Location: "http://example.com", ChangeFrequency: Monthly})
+	w.Write(Page{Location: "http://example.com/live", ChangeFrequency: Always})
+	w.Write(Page{Location: "http://example.com/about"})
+	w.Close()
+
+	if !strings.Contains(buf.String(), "<changefreq>monthly</changefreq>") {
+		t.Errorf("Expected protocol changefreq value, got %q", buf.String())
+	}
+	if !strings.Contains(buf.String(), "<changefreq>always</changefreq>") {
+		t.Errorf("Expected changefreq always to be written, got %q", buf.String())
+	}
+	if strings.Count(buf.String(), "<changefreq>") != 2 {
+		t.Errorf("Expected no changefreq for an unset value, got %q", buf.String())
+	}
+
+	for _, freq := range []Frequency{None, Always, Never} {
+		data, err := json.Marshal(Page{ChangeFrequency: freq})
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		var page Page
+		if err := json.Unmarshal(data, &page); err != nil || page.ChangeFrequency != freq {
+			t.Errorf("Expected %v to round-trip, got %v (%v)", freq, page.ChangeFrequency, err)
+		}
+	}
+
+	var freq Frequency
+	if err := freq.UnmarshalText([]byte("HOURLY")); err != nil || freq != Hourly {
+		t.Errorf("Expected hourly, got %v (%v)", freq, err)
+	}
+	if _, err := ParseFrequency("sometimes"); err == nil {
+		t.Errorf("Expected error for unknown changefreq")
+	}
+}
+
+func TestFrequencyFor(t *testing.T) {
+	testData := []struct {
+		interval time.Duration
+		expected Frequency
+	}{
+		{30 * time.Minute, Hourly},
+		{12 * time.Hour, Daily},
+		{5 * 24 * time.Hour, Weekly},
+		{40 * 24 * time.Hour, Monthly},
+		{200 * 24 * time.Hour, Yearly},
+		{math.MaxInt64, Yearly},
+	}
+
+	for _, test := range testData {
+		if freq := FrequencyFor(test.interval); freq != test.expected {
+			t.Errorf("Expected %v for %v, got %v", test.expected, test.interval, freq)
+		}
+	}
+}
+
+func TestExtractScopedAnchors(t *testing.T) {
+	pageUrl, _ := url.Parse("http://example.com/")
+	html := `<a href="/a">About <b>us</b></a> <a class="x" href="/b"><img src="/logo.png"></a> <a href="/c">Fish &amp; Chips</A> <a href="/d">unclosed`
+
+	anchors := extractScopedAnchors(html, pageUrl, nil, DefaultLinkFilter)
+
+	expected := []Anchor{
+		{URL: "http://example.com/a", Text: "About us"},
+		{URL: "http://example.com/b"},
+		{URL: "http://example.com/c", Text: "Fish & Chips"},
+		{URL: "http://example.com/d"},
+	}
+	if !reflect.DeepEqual(anchors, expected) {
+		t.Errorf("Expected %v, got %v", expected, anchors)
+	}
+}
+
+func TestFindBrokenLinks(t *testing.T) {
+	pages := []Page{
+		{Location: "http://example.com/", StatusCode: 200, Anchors: []Anchor{
+			{URL: "http://example.com/missing", Text: "Missing"},
+			{URL: "http://example.com/missing", Text: "Missing"},
+			{URL: "http://example.com/down", Text: "Down"},
+			{URL: "http://example.com/ok", Text: "OK"},
+		}},
+		{Location: "http://example.com/ok", StatusCode: 200, Anchors: []Anchor{
+			{URL: "http://example.com/missing", Text: "Gone"},
+		}},
+		{Location: "http://example.com/missing", StatusCode: 404},
+	}
+	failures := map[string]string{"http://example.com/down": "connection refused"}
+
+	links := FindBrokenLinks(pages, failures, nil)
+
+	expected := []BrokenLink{
+		{URL: "http://example.com/down", Error: "connection refused", Referrers: []Referrer{{Page: "http://example.com/", Text: "Down"}}},
+		{URL: "http://example.com/missing", Status: 404, Referrers: []Referrer{
+			{Page: "http://example.com/", Text: "Missing"},
+			{Page: "http://example.com/ok", Text: "Gone"},
+		}},
+	}
+	if !reflect.DeepEqual(links, expected) {
+		t.Fatalf("Expected %+v, got %+v", expected, links)
+	}
+
+	var buf bytes.Buffer
+	if err := WriteBrokenLinks(&buf, links); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	text := "http://example.com/down\tconnection refused\n" +
+		"\tlinked from http://example.com/ \"Down\"\n" +
+		"http://example.com/missing\t404 Not Found\n" +
+		"\tlinked from http://example.com/ \"Missing\"\n" +
+		"\tlinked from http://example.com/ok \"Gone\"\n"
+	if buf.String() != text {
+		t.Errorf("Expected %q, got %q", text, buf.String())
+	}
+
+	buf.Reset()
+	if err := WriteBrokenLinksJSON(&buf, links); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	var decoded []BrokenLink
+	if err := json.Unmarshal(buf.Bytes(), &decoded); err != nil || !reflect.DeepEqual(decoded, links) {
+		t.Errorf("Expected JSON report to round trip, got %v (%v)", decoded, err)
+	}
+}
+
+func TestParser_Redirects(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/old":
+			http.Redirect(w, r, "/older", http.StatusFound)
+		case "/older":
+			http.Redirect(w, r, "/new", http.StatusMovedPermanently)
+		case "/new":
+			fmt.Fprint(w, `<a href="/a">A</a>`)
+		case "/loop-a":
+			http.Redirect(w, r, "/loop-b", http.StatusFound)
+		case "/loop-b":
+			http.Redirect(w, r, "/loop-a", http.StatusFound)
+		case "/away":
+			http.Redirect(w, r, "http://other.example/", http.StatusMovedPermanently)
+		}
+	}))
+	defer server.Close()
+
+	parser := &Parser{}
+	page, err := parser.Parse(server.URL+"/old", Validators{})
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if page.Location != server.URL+"/new" {
+		t.Errorf("Expected final URL, got %s", page.Location)
+	}
+	expected := []Hop{{URL: server.URL + "/old", Status: http.StatusFound}, {URL: server.URL + "/older", Status: http.StatusMovedPermanently}}
+	if !reflect.DeepEqual(page.Redirects, expected) {
+		t.Errorf("Expected hops %v, got %v", expected, page.Redirects)
+	}
+
+	testData := []struct {
+		path     string
+		parser   *Parser
+		expected error
+		hops     int
+	}{
+		{"/loop-a", parser, ErrRedirectLoop, 2},
+		{"/away", parser, ErrNotSameHost, 1},
+		{"/old", &Parser{MaxRedirects: 1}, ErrTooManyRedirects, 2},
+	}
+
+	for _, test := range testData {
+		_, err := test.parser.Parse(server.URL+test.path, Validators{})
+
+		var redirectErr *RedirectError
+		if !errors.As(err, &redirectErr) || !errors.Is(err, test.expected) || len(redirectErr.Chain.Hops) != test.hops {
+			t.Errorf("Expected %v after %d hops for %s, got %v", test.expected, test.hops, test.path, err)
+		}
+	}
+}
+
+func TestAuditRedirects(t *testing.T) {
+	chains := []RedirectChain{
+		{Hops: []Hop{{"http://example.com/a", 301}}, Final: "http://example.com/b"},
+		{Hops: []Hop{{"http://example.com/c", 301}, {"http://example.com/d", 308}}, Final: "http://example.com/e"},
+		{Hops: []Hop{{"http://example.com/f", 302}}, Final: "http://example.com/g"},
+		{Hops: []Hop{{"http://example.com/h", 302}, {"http://example.com/i", 302}}, Final: "http://example.com/h", Err: ErrRedirectLoop},
+		{Hops: []Hop{{"http://example.com/j", 301}}, Final: "http://other.com/", Err: ErrNotSameHost},
+	}
+
+	var issues []string
+	for _, issue := range AuditRedirects(chains, 1) {
+		issues = append(issues, issue.String())
+	}
+
+	expected := []string{
+		"cross-host: http://example.com/j -301-> http://other.com/",
+		"long-chain: http://example.com/c -301-> http://example.com/d -308-> http://example.com/e",
+		"loop: http://example.com/h -302-> http://example.com/i -302-> http://example.com/h",
+		"temporary: http://example.com/f -302-> http://example.com/g",
+	}
+	if !reflect.DeepEqual(issues, expected) {
+		t.Errorf("Expected %q, got %q", expected, issues)
+	}
+
+	usage := RedirectUsage(chains)
+	if usage[301] != 3 || usage[302] != 3 || usage[308] != 1 {
+		t.Errorf("Expected redirect usage by status, got %v", usage)
+	}
+
+	err := &RedirectError{Chain: chains[4]}
+	if err.Error() != "dropped redirect to another host: http://example.com/j -301-> http://other.com/" {
+		t.Errorf("Expected a clear reason for cross-host redirects, got %q", err.Error())
+	}
+}
+
+func TestFindBrokenLinks_Redirects(t *testing.T) {
+	pages := []Page{
+		{Location: "http://example.com/", StatusCode: 200, Anchors: []Anchor{
+			{URL: "http://example.com/old", Text: "Old"},
+			{URL: "http://example.com/moved", Text: "Moved"},
+		}},
+		{Location: "http://example.com/gone", StatusCode: 404, Redirects: []Hop{{URL: "http://example.com/old", Status: 301}}},
+	}
+	chains := []RedirectChain{
+		{Hops: []Hop{{URL: "http://example.com/old", Status: 301}}, Final: "http://example.com/gone"},
+		{Hops: []Hop{{URL: "http://example.com/moved", Status: 302}}, Final: "http://example.com/gone"},
+	}
+
+	expected := []BrokenLink{{URL: "http://example.com/gone", Status: 404, Referrers: []Referrer{{Page: "http://example.com/", Text: "Old"}}}}
+	if links := FindBrokenLinks(pages, nil, nil); !reflect.DeepEqual(links, expected) {
+		t.Errorf("Expected the redirected URL's referrers, got %+v", links)
+	}
+
+	expected[0].Referrers = append(expected[0].Referrers, Referrer{Page: "http://example.com/", Text: "Moved"})
+	if links := FindBrokenLinks(pages, nil, chains); !reflect.DeepEqual(links, expected) {
+		t.Errorf("Expected referrers of every URL redirecting to the broken page, got %+v", links)
+	}
+}
+
+func TestParser_RedirectValidators(t *testing.T) {
+	conditional := make(map[string]string)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conditional[r.URL.Path] = r.Header.Get("If-None-Match")
+		switch r.URL.Path {
+		case "/old":
+			http.Redirect(w, r, "/new", http.StatusMovedPermanently)
+		case "/new":
+			if r.Header.Get("If-None-Match") == `"new-v1"` {
+				w.WriteHeader(http.StatusNotModified)
+				return
+			}
+			fmt.Fprint(w, "new")
+		}
+	}))
+	defer server.Close()
+
+	if _, err := new(Parser).Parse(server.URL+"/old", Validators{ETag: `"old-v1"`}); err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if conditional["/old"] != `"old-v1"` || conditional["/new"] != "" {
+		t.Errorf("Expected validators to be sent to the requested URL only, got %v", conditional)
+	}
+
+	saved := map[string]Validators{server.URL + "/new": {ETag: `"new-v1"`}}
+	page, err := new(Parser).ParseConditional(server.URL+"/old", func(URL string) Validators { return saved[URL] })
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if conditional["/old"] != "" || conditional["/new"] != `"new-v1"` {
+		t.Errorf("Expected each URL to get its own validators, got %v", conditional)
+	}
+	if !page.NotModified || page.Location != server.URL+"/new" || page.Validators.ETag != `"new-v1"` || len(page.Redirects) != 1 {
+		t.Errorf("Expected the redirected page to be revalidated, got %+v", page)
+	}
+}
@@ -0,0 +1,248 @@
+package sitemap
+
+import (
+	"bufio"
+	"container/heap"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+)
+
+type SortOrder string
+
+const (
+	SortNone     SortOrder = ""
+	SortLoc      SortOrder = "loc"
+	SortPriority SortOrder = "priority"
+	SortDepth    SortOrder = "depth"
+)
+
+// DefaultSortMemory is the default budget, in bytes, of entries held in
+// memory by a SortedWriter before they are spilled to a temp file.
+const DefaultSortMemory = 64 << 20
+
+func ParseSortOrder(order string) (SortOrder, error) {
+	switch SortOrder(order) {
+	case SortNone, SortLoc, SortPriority, SortDepth:
+		return SortOrder(order), nil
+	}
+	return SortNone, fmt.Errorf("unknown sort order %q", order)
+}
+
+// Less reports whether a sorts before b: by loc, by priority (highest first)
+// then loc, or by depth then loc.
+func (o SortOrder) Less(a, b Page) bool {
+	switch o {
+	case SortPriority:
+		if a.Priority != b.Priority {
+			return a.Priority > b.Priority
+		}
+	case SortDepth:
+		if a.Depth != b.Depth {
+			return a.Depth < b.Depth
+		}
+	}
+	return a.Location < b.Location
+}
+
+// SortedWriter buffers pages and writes them to the underlying Writer in
+// order on Close, keeping the order pages were written in among pages that
+// compare equal. Once the buffered entries exceed the memory budget they are
+// sorted and spilled as a run to a temp file, and the runs are merged when the
+// writer is closed.
+type SortedWriter struct {
+	w      Writer
+	order  SortOrder
+	budget int
+
+	pages []Page
+	size  int
+
+	spill *os.File
+	runs  []sortRun
+}
+
+type sortRun struct {
+	offset int64
+	length int64
+}
+
+func NewSortedWriter(w Writer, order SortOrder, budget int) *SortedWriter {
+	return &SortedWriter{w: w, order: order, budget: budget}
+}
+
+func (sw *SortedWriter) Write(page Page) error {
+	data, err := json.Marshal(page)
+	if err != nil {
+		return err
+	}
+
+	sw.pages = append(sw.pages, page)
+	sw.size += len(data)
+
+	if sw.size > sw.budget {
+		return sw.spillRun()
+	}
+	return nil
+}
+
+func (sw *SortedWriter) sort() {
+	sort.SliceStable(sw.pages, func(i, j int) bool {
+		return sw.order.Less(sw.pages[i], sw.pages[j])
+	})
+}
+
+func (sw *SortedWriter) spillRun() error {
+	if sw.spill == nil {
+		file, err := ioutil.TempFile("", "sitemap-sort-*.jsonl")
+		if err != nil {
+			return err
+		}
+		sw.spill = file
+	}
+
+	offset, err := sw.spill.Seek(0, io.SeekEnd)
+	if err != nil {
+		return err
+	}
+
+	sw.sort()
+	out := bufio.NewWriter(sw.spill)
+	encoder := json.NewEncoder(out)
+	for _, page := range sw.pages {
+		if err := encoder.Encode(page); err != nil {
+			return err
+		}
+	}
+	if err := out.Flush(); err != nil {
+		return err
+	}
+
+	end, err := sw.spill.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+
+	sw.runs = append(sw.runs, sortRun{offset: offset, length: end - offset})
+	sw.pages = nil
+	sw.size = 0
+	return nil
+}
+
+func (sw *SortedWriter) Close() error {
+	if sw.spill != nil {
+		defer os.Remove(sw.spill.Name())
+		defer sw.spill.Close()
+	}
+
+	if err := sw.writeSorted(); err != nil {
+		sw.w.Close()
+		return err
+	}
+
+	return sw.w.Close()
+}
+
+func (sw *SortedWriter) writeSorted() error {
+	if sw.spill == nil {
+		sw.sort()
+		for _, page := range sw.pages {
+			if err := sw.w.Write(page); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	if len(sw.pages) > 0 {
+		if err := sw.spillRun(); err != nil {
+			return err
+		}
+	}
+
+	return sw.merge()
+}
+
+// merge writes the spilled runs out in order with a k-way merge.
+func (sw *SortedWriter) merge() error {
+	queue := &runQueue{order: sw.order}
+	for i, run := range sw.runs {
+		decoder := json.NewDecoder(bufio.NewReader(io.NewSectionReader(sw.spill, run.offset, run.length)))
+		head := &runHead{decoder: decoder, run: i}
+		ok, err := head.next()
+		if err != nil {
+			return err
+		}
+		if ok {
+			queue.heads = append(queue.heads, head)
+		}
+	}
+	heap.Init(queue)
+
+	for queue.Len() > 0 {
+		head := queue.heads[0]
+		if err := sw.w.Write(head.page); err != nil {
+			return err
+		}
+
+		ok, err := head.next()
+		if err != nil {
+			return err
+		}
+		if ok {
+			heap.Fix(queue, 0)
+		} else {
+			heap.Pop(queue)
+		}
+	}
+
+	return nil
+}
+
+type runHead struct {
+	decoder *json.Decoder
+	page    Page
+	run     int
+}
+
+func (h *runHead) next() (bool, error) {
+	h.page = Page{}
+	if err := h.decoder.Decode(&h.page); err != nil {
+		if err == io.EOF {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+type runQueue struct {
+	order SortOrder
+	heads []*runHead
+}
+
+func (q *runQueue) Len() int           { return len(q.heads) }
+func (q *runQueue) Swap(i, j int)      { q.heads[i], q.heads[j] = q.heads[j], q.heads[i] }
+func (q *runQueue) Push(x interface{}) { q.heads = append(q.heads, x.(*runHead)) }
+
+// Less takes equal pages from earlier runs first, so merging keeps them in
+// the order they were written in.
+func (q *runQueue) Less(i, j int) bool {
+	a, b := q.heads[i], q.heads[j]
+	if q.order.Less(a.page, b.page) {
+		return true
+	}
+	if q.order.Less(b.page, a.page) {
+		return false
+	}
+	return a.run < b.run
+}
+
+func (q *runQueue) Pop() interface{} {
+	head := q.heads[len(q.heads)-1]
+	q.heads = q.heads[:len(q.heads)-1]
+	return head
+}
@@ -0,0 +1,25 @@
+package sitemap
+
+import (
+	_ "embed"
+	"encoding/xml"
+	"strings"
+)
+
+// DefaultStylesheet is a bundled XSL stylesheet rendering urlset and
+// sitemapindex documents as a sortable HTML table in the browser.
+//
+//go:embed sitemap.xsl
+var DefaultStylesheet []byte
+
+// DefaultStylesheetName is the file name the bundled stylesheet is written
+// under next to the sitemap.
+const DefaultStylesheetName = "sitemap.xsl"
+
+// StylesheetPI returns the processing instruction that makes browsers render
+// a sitemap with the XSL stylesheet at href.
+func StylesheetPI(href string) string {
+	var escaped strings.Builder
+	xml.EscapeText(&escaped, []byte(href))
+	return `<?xml-stylesheet type="text/xsl" href="` + escaped.String() + `"?>`
+}
@@ -0,0 +1,176 @@
+package sitemap
+
+import (
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+// Trap kinds reported by TrapDetector.
+const (
+	TrapRepeatingSegments = "repeating-segments"
+	TrapPathDepth         = "path-depth"
+	TrapURLLength         = "url-length"
+	TrapQueryVariants     = "query-variants"
+)
+
+// TrapLimits bound URLs before they are considered crawler traps. A zero
+// limit disables its check.
+type TrapLimits struct {
+	// MaxRepeats is how often one path segment may occur in a path.
+	MaxRepeats int
+	// MaxPathDepth is the maximum number of path segments.
+	MaxPathDepth int
+	// MaxURLLength is the maximum length of the whole URL.
+	MaxURLLength int
+	// MaxQueryVariants is how many distinct query strings are crawled per
+	// path and set of query parameters.
+	MaxQueryVariants int
+}
+
+var DefaultTrapLimits = TrapLimits{
+	MaxRepeats:       2,
+	MaxPathDepth:     12,
+	MaxURLLength:     2048,
+	MaxQueryVariants: 100,
+}
+
+// Trap is a URL template the detector cut off, with the number of URLs
+// matching it that were not crawled.
+type Trap struct {
+	Kind     string
+	Template string
+	URLs     int
+}
+
+func (t Trap) String() string {
+	return fmt.Sprintf("%s: %s (%d URLs cut off)", t.Kind, t.Template, t.URLs)
+}
+
+// TrapDetector flags URLs that look like crawler traps: ever-growing paths
+// and endless query variations such as calendars or faceted navigation.
+type TrapDetector struct {
+	limits   TrapLimits
+	variants map[string]map[string]bool
+	traps    map[string]*Trap
+}
+
+func NewTrapDetector(limits TrapLimits) *TrapDetector {
+	return &TrapDetector{
+		limits:   limits,
+		variants: make(map[string]map[string]bool),
+		traps:    make(map[string]*Trap),
+	}
+}
+
+// Check reports whether u should not be crawled and records it under its
+// template if so. Every URL must be checked only once, as each checked query
+// string counts as a variant of its template.
+func (d *TrapDetector) Check(u *url.URL) bool {
+	kind, template := d.classify(u)
+	if kind == "" {
+		return false
+	}
+
+	key := kind + " " + template
+	trap, ok := d.traps[key]
+	if !ok {
+		trap = &Trap{Kind: kind, Template: template}
+		d.traps[key] = trap
+	}
+	trap.URLs++
+
+	return true
+}
+
+func (d *TrapDetector) classify(u *url.URL) (string, string) {
+	segments := strings.Split(strings.Trim(u.Path, "/"), "/")
+	origin := u.Scheme + "://" + u.Host + "/"
+
+	if d.limits.MaxRepeats > 0 {
+		counts := make(map[string]int)
+		for _, segment := range segments {
+			if segment == "" {
+				continue
+			}
+			if counts[segment]++; counts[segment] > d.limits.MaxRepeats {
+				first := indexOf(segments, segment)
+				return TrapRepeatingSegments, origin + strings.Join(segments[:first+1], "/") + "/…"
+			}
+		}
+	}
+
+	if d.limits.MaxPathDepth > 0 && len(segments) > d.limits.MaxPathDepth {
+		return TrapPathDepth, origin + strings.Join(segments[:d.limits.MaxPathDepth], "/") + "/…"
+	}
+
+	template := QueryTemplate(u)
+
+	if d.limits.MaxURLLength > 0 && len(u.String()) > d.limits.MaxURLLength {
+		return TrapURLLength, template
+	}
+
+	if d.limits.MaxQueryVariants > 0 && u.RawQuery != "" {
+		variants, ok := d.variants[template]
+		if !ok {
+			variants = make(map[string]bool)
+			d.variants[template] = variants
+		}
+		if !variants[u.RawQuery] && len(variants) >= d.limits.MaxQueryVariants {
+			return TrapQueryVariants, template
+		}
+		variants[u.RawQuery] = true
+	}
+
+	return "", ""
+}
+
+func indexOf(items []string, item string) int {
+	for i := range items {
+		if items[i] == item {
+			return i
+		}
+	}
+	return -1
+}
+
+// QueryTemplate returns u without its fragment and with every query value
+// replaced by *, parameters sorted by name, e.g.
+// http://example.com/calendar?month=*&year=*.
+func QueryTemplate(u *url.URL) string {
+	template := u.Scheme + "://" + u.Host + u.EscapedPath()
+
+	query := u.Query()
+	if len(query) == 0 {
+		return template
+	}
+
+	keys := make([]string, 0, len(query))
+	for key := range query {
+		keys = append(keys, url.QueryEscape(key)+"=*")
+	}
+	sort.Strings(keys)
+
+	return template + "?" + strings.Join(keys, "&")
+}
+
+// Traps returns the templates that were cut off, most URLs first.
+func (d *TrapDetector) Traps() []Trap {
+	traps := make([]Trap, 0, len(d.traps))
+	for _, trap := range d.traps {
+		traps = append(traps, *trap)
+	}
+
+	sort.Slice(traps, func(i, j int) bool {
+		if traps[i].URLs != traps[j].URLs {
+			return traps[i].URLs > traps[j].URLs
+		}
+		if traps[i].Template != traps[j].Template {
+			return traps[i].Template < traps[j].Template
+		}
+		return traps[i].Kind < traps[j].Kind
+	})
+
+	return traps
+}
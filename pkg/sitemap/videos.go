@@ -0,0 +1,236 @@
+package sitemap
+
+import (
+	"html"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type Video struct {
+	ThumbnailLocation string     `xml:"video:thumbnail_loc"`
+	Title             string     `xml:"video:title"`
+	Description       string     `xml:"video:description"`
+	ContentLocation   string     `xml:"video:content_loc,omitempty"`
+	PlayerLocation    string     `xml:"video:player_loc,omitempty"`
+	Duration          int        `xml:"video:duration,omitempty"`
+	PublicationDate   *time.Time `xml:"video:publication_date,omitempty"`
+}
+
+var videoBlockPattern = regexp.MustCompile(`(?is)<video\b[^>]*>.*?</video>|<video\b[^>]*/>`)
+var videoTagPattern = regexp.MustCompile(`(?is)^<video\b[^>]*>`)
+var titlePattern = regexp.MustCompile(`(?is)<title\b[^>]*>(.*?)</title>`)
+var isoDurationPattern = regexp.MustCompile(`^P(?:(\d+)D)?(?:T(?:(\d+)H)?(?:(\d+)M)?(?:(\d+(?:\.\d+)?)S)?)?$`)
+
+// extractVideos collects videos from <video> elements, Open Graph video tags
+// and JSON-LD VideoObjects. Entries describing the same URL are merged, and
+// videos without a location or thumbnail are dropped because search engines
+// reject them.
+func (p *Parser) extractVideos(page string, base *url.URL) []Video {
+	var candidates []Video
+
+	for _, block := range videoBlockPattern.FindAllString(page, -1) {
+		candidates = append(candidates, htmlVideo(block, base))
+	}
+	candidates = append(candidates, openGraphVideo(page, base))
+	for _, object := range jsonLDObjects(jsonLDDocuments(page), "VideoObject") {
+		candidates = append(candidates, jsonLDVideo(object, base))
+	}
+
+	var videos []Video
+	index := make(map[string]int)
+
+	for _, candidate := range candidates {
+		key := candidate.ContentLocation
+		if key == "" {
+			key = candidate.PlayerLocation
+		}
+		if key == "" {
+			continue
+		}
+
+		if i, ok := index[key]; ok {
+			videos[i] = mergeVideo(videos[i], candidate)
+			continue
+		}
+
+		index[key] = len(videos)
+		videos = append(videos, candidate)
+	}
+
+	title := PageTitle(page)
+	description := MetaDescription(page)
+
+	var complete []Video
+	for _, video := range videos {
+		if video.Title == "" {
+			video.Title = title
+		}
+		if video.Description == "" {
+			video.Description = description
+		}
+		if video.Description == "" {
+			video.Description = video.Title
+		}
+		if video.ThumbnailLocation != "" {
+			complete = append(complete, video)
+		}
+	}
+
+	return complete
+}
+
+func htmlVideo(block string, base *url.URL) Video {
+	attributes := parseAttributes(videoTagPattern.FindString(block))
+
+	video := Video{
+		ContentLocation:   resolvedString(base, attributes["src"]),
+		ThumbnailLocation: resolvedString(base, attributes["poster"]),
+		Title:             attributes["title"],
+	}
+
+	if video.Title == "" {
+		video.Title = attributes["aria-label"]
+	}
+
+	if video.ContentLocation == "" {
+		for _, source := range findTags(sourceTagPattern, block) {
+			if loc := resolvedString(base, source["src"]); loc != "" {
+				video.ContentLocation = loc
+				break
+			}
+		}
+	}
+
+	return video
+}
+
+func openGraphVideo(page string, base *url.URL) Video {
+	properties := make(map[string]string)
+	for _, meta := range findTags(metaTagPattern, page) {
+		name := strings.ToLower(meta["property"])
+		if name == "" {
+			name = strings.ToLower(meta["name"])
+		}
+		if _, ok := properties[name]; name != "" && !ok {
+			properties[name] = meta["content"]
+		}
+	}
+
+	loc := properties["og:video:secure_url"]
+	if loc == "" {
+		loc = properties["og:video:url"]
+	}
+	if loc == "" {
+		loc = properties["og:video"]
+	}
+
+	video := Video{
+		ThumbnailLocation: resolvedString(base, properties["og:image"]),
+		Title:             properties["og:title"],
+		Description:       properties["og:description"],
+	}
+
+	if strings.Contains(strings.ToLower(properties["og:video:type"]), "html") {
+		video.PlayerLocation = resolvedString(base, loc)
+	} else {
+		video.ContentLocation = resolvedString(base, loc)
+	}
+
+	if seconds, err := strconv.Atoi(strings.TrimSpace(properties["video:duration"])); err == nil {
+		video.Duration = seconds
+	}
+
+	if date, err := ParseDate(properties["video:release_date"]); err == nil {
+		video.PublicationDate = &date
+	}
+
+	return video
+}
+
+func jsonLDVideo(object map[string]interface{}, base *url.URL) Video {
+	video := Video{
+		ContentLocation:   resolvedString(base, jsonLDString(object, "contentUrl")),
+		PlayerLocation:    resolvedString(base, jsonLDString(object, "embedUrl")),
+		ThumbnailLocation: resolvedString(base, jsonLDString(object, "thumbnailUrl")),
+		Title:             jsonLDString(object, "name"),
+		Description:       jsonLDString(object, "description"),
+		Duration:          parseISODuration(jsonLDString(object, "duration")),
+	}
+
+	if date, err := ParseDate(jsonLDString(object, "uploadDate")); err == nil {
+		video.PublicationDate = &date
+	}
+
+	return video
+}
+
+// mergeVideo fills the fields missing from a with those of b.
+func mergeVideo(a, b Video) Video {
+	if a.ThumbnailLocation == "" {
+		a.ThumbnailLocation = b.ThumbnailLocation
+	}
+	if a.Title == "" {
+		a.Title = b.Title
+	}
+	if a.Description == "" {
+		a.Description = b.Description
+	}
+	if a.ContentLocation == "" {
+		a.ContentLocation = b.ContentLocation
+	}
+	if a.PlayerLocation == "" {
+		a.PlayerLocation = b.PlayerLocation
+	}
+	if a.Duration == 0 {
+		a.Duration = b.Duration
+	}
+	if a.PublicationDate == nil {
+		a.PublicationDate = b.PublicationDate
+	}
+	return a
+}
+
+// parseISODuration converts an ISO 8601 duration such as PT1M30S to whole
+// seconds, returning 0 when the value can't be parsed.
+func parseISODuration(value string) int {
+	match := isoDurationPattern.FindStringSubmatch(strings.ToUpper(strings.TrimSpace(value)))
+	if match == nil {
+		return 0
+	}
+
+	var seconds float64
+	for i, unit := range []float64{86400, 3600, 60, 1} {
+		if match[i+1] != "" {
+			n, _ := strconv.ParseFloat(match[i+1], 64)
+			seconds += n * unit
+		}
+	}
+
+	return int(seconds)
+}
+
+func resolvedString(base *url.URL, ref string) string {
+	if u, ok := resolveURL(base, ref); ok {
+		return u.String()
+	}
+	return ""
+}
+
+func PageTitle(page string) string {
+	if match := titlePattern.FindStringSubmatch(page); match != nil {
+		return strings.TrimSpace(whitespacePattern.ReplaceAllString(html.UnescapeString(match[1]), " "))
+	}
+	return ""
+}
+
+func MetaDescription(page string) string {
+	for _, meta := range findTags(metaTagPattern, page) {
+		if strings.EqualFold(meta["name"], "description") {
+			return strings.TrimSpace(meta["content"])
+		}
+	}
+	return ""
+}
@@ -0,0 +1,80 @@
+package sitemap
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"strings"
+)
+
+type Writer interface {
+	Write(page Page) error
+	Close() error
+}
+
+type TextWriter struct {
+	w *bufio.Writer
+}
+
+// NewTextWriter writes the protocol's text sitemap format: one URL per line.
+func NewTextWriter(w io.Writer) *TextWriter {
+	return &TextWriter{w: bufio.NewWriter(w)}
+}
+
+func (tw *TextWriter) Write(page Page) error {
+	_, err := tw.w.WriteString(page.Location + "\n")
+	return err
+}
+
+func (tw *TextWriter) Close() error {
+	return tw.w.Flush()
+}
+
+type JSONLWriter struct {
+	w       *bufio.Writer
+	encoder *json.Encoder
+}
+
+type jsonlRecord struct {
+	Location        string   `json:"loc"`
+	Status          int      `json:"status"`
+	Depth           int      `json:"depth"`
+	LastModified    string   `json:"lastmod,omitempty"`
+	Priority        float64  `json:"priority,omitempty"`
+	ChangeFrequency string   `json:"changefreq,omitempty"`
+	Links           []string `json:"links"`
+	FetchMillis     float64  `json:"fetch_ms"`
+}
+
+// NewJSONLWriter writes one JSON object per page with its crawl data.
+func NewJSONLWriter(w io.Writer) *JSONLWriter {
+	buffered := bufio.NewWriter(w)
+	return &JSONLWriter{w: buffered, encoder: json.NewEncoder(buffered)}
+}
+
+func (jw *JSONLWriter) Write(page Page) error {
+	record := jsonlRecord{
+		Location:    page.Location,
+		Status:      page.StatusCode,
+		Depth:       page.Depth,
+		Priority:    page.Priority,
+		Links:       page.Links,
+		FetchMillis: float64(page.FetchDuration.Microseconds()) / 1000,
+	}
+
+	if record.Links == nil {
+		record.Links = []string{}
+	}
+	if page.LastModified != nil && !page.LastModified.IsZero() {
+		record.LastModified = page.LastModified.Format(W3CDatetime)
+	}
+	if page.ChangeFrequency != None {
+		record.ChangeFrequency = strings.ToLower(page.ChangeFrequency.String())
+	}
+
+	return jw.encoder.Encode(record)
+}
+
+func (jw *JSONLWriter) Close() error {
+	return jw.w.Flush()
+}
@@ -0,0 +1,87 @@
+package sitemap
+
+import (
+	"bufio"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strings"
+)
+
+const SitemapNamespace = "http://www.sitemaps.org/schemas/sitemap/0.9"
+
+type Extension struct {
+	Prefix    string
+	Namespace string
+}
+
+var ImageExtension = Extension{Prefix: "image", Namespace: "http://www.google.com/schemas/sitemap-image/1.1"}
+var VideoExtension = Extension{Prefix: "video", Namespace: "http://www.google.com/schemas/sitemap-video/1.1"}
+
+// URLSetStart returns the opening urlset tag declaring the sitemap namespace
+// and the namespaces of the given extensions.
+func URLSetStart(extensions ...Extension) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, `<urlset xmlns="%s"`, SitemapNamespace)
+	for _, ext := range extensions {
+		fmt.Fprintf(&b, ` xmlns:%s="%s"`, ext.Prefix, ext.Namespace)
+	}
+	b.WriteString(">")
+	return b.String()
+}
+
+type XMLWriter struct {
+	// Stylesheet, when set before the first write, is referenced from an
+	// xml-stylesheet processing instruction ahead of the urlset.
+	Stylesheet string
+
+	w          *bufio.Writer
+	extensions []Extension
+	started    bool
+}
+
+func NewXMLWriter(w io.Writer, extensions ...Extension) *XMLWriter {
+	return &XMLWriter{w: bufio.NewWriter(w), extensions: extensions}
+}
+
+func (xw *XMLWriter) start() error {
+	if xw.started {
+		return nil
+	}
+	xw.started = true
+
+	if xw.Stylesheet != "" {
+		if _, err := xw.w.WriteString(StylesheetPI(xw.Stylesheet) + "\n"); err != nil {
+			return err
+		}
+	}
+
+	_, err := xw.w.WriteString(URLSetStart(xw.extensions...) + "\n")
+	return err
+}
+
+func (xw *XMLWriter) Write(page Page) error {
+	if err := xw.start(); err != nil {
+		return err
+	}
+
+	data, err := xml.MarshalIndent(page, " ", "  ")
+	if err != nil {
+		return err
+	}
+
+	_, err = xw.w.Write(data)
+	return err
+}
+
+func (xw *XMLWriter) Close() error {
+	if err := xw.start(); err != nil {
+		return err
+	}
+
+	if _, err := xw.w.WriteString("\n</urlset>"); err != nil {
+		return err
+	}
+
+	return xw.w.Flush()
+}